@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// HASH_LENGTH is how many hex digits abbreviated hashes have at least.
+// Repositories where that is ambiguous get longer ones.
+var HASH_LENGTH = 8
+
+type abbrevLength struct {
+	refsKey string
+	min     int
+	length  int
+}
+
+// abbrevLengths caches AbbrevLength per repository directory and refs.
+var abbrevLengths sync.Map
+
+// Abbreviate shortens a hash to length hex digits, or HASH_LENGTH when
+// length is 0.
+func Abbreviate(hash plumbing.Hash, length int) string {
+	if length <= 0 {
+		length = HASH_LENGTH
+	}
+	s := hash.String()
+	if length > len(s) {
+		length = len(s)
+	}
+	return s[:length]
+}
+
+// commonHexPrefix returns how many leading hex digits a and b share.
+func commonHexPrefix(a, b plumbing.Hash) int {
+	n := 0
+	for i := range a {
+		if a[i] == b[i] {
+			n += 2
+			continue
+		}
+		if a[i]>>4 == b[i]>>4 {
+			n++
+		}
+		break
+	}
+	return n
+}
+
+// UniqueAbbrevLength returns the fewest hex digits, but at least min, that
+// tell every hash in hashes apart.
+func UniqueAbbrevLength(hashes []plumbing.Hash, min int) int {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	length := min
+	for i := 1; i < len(hashes); i++ {
+		if n := commonHexPrefix(hashes[i-1], hashes[i]) + 1; n > length {
+			length = n
+		}
+	}
+	if length > 2*len(plumbing.ZeroHash) {
+		length = 2 * len(plumbing.ZeroHash)
+	}
+	return length
+}
+
+// AbbrevLength returns how long abbreviated hashes of a repository are, so
+// that none of them is ambiguous among its objects. It is recomputed when
+// the refs of the repository move.
+func AbbrevLength(r *git.Repository) int {
+	objects, ok := r.Storer.(interface {
+		HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
+	})
+	dot, hasDir := r.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok || !hasDir {
+		return HASH_LENGTH
+	}
+	key, err := refsKey(r)
+	if err != nil {
+		return HASH_LENGTH
+	}
+	dir := dot.Filesystem().Root()
+	if v, ok := abbrevLengths.Load(dir); ok && v.(abbrevLength).refsKey == key && v.(abbrevLength).min == HASH_LENGTH {
+		return v.(abbrevLength).length
+	}
+	hashes, err := objects.HashesWithPrefix(nil)
+	if err != nil {
+		return HASH_LENGTH
+	}
+	length := UniqueAbbrevLength(hashes, HASH_LENGTH)
+	abbrevLengths.Store(dir, abbrevLength{refsKey: key, min: HASH_LENGTH, length: length})
+	return length
+}
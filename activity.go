@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	// ACTIVITY_WEEKS is how many weeks the activity graph covers.
+	ACTIVITY_WEEKS = 52
+	// ACTIVITY_BAR_WIDTH and ACTIVITY_HEIGHT size the bars of the graph,
+	// in pixels.
+	ACTIVITY_BAR_WIDTH = 6
+	ACTIVITY_HEIGHT    = 30
+)
+
+type WeekActivity struct {
+	// Week is the Monday the week starts on, in UTC.
+	Week    time.Time `json:"week"`
+	Commits int       `json:"commits"`
+}
+
+// Activity counts the commits of the latest weeks, oldest first.
+type Activity struct {
+	Weeks []WeekActivity `json:"weeks"`
+	Max   int            `json:"max"`
+	Total int            `json:"total"`
+}
+
+// ActivityBar is a bar of the activity graph.
+type ActivityBar struct {
+	WeekActivity
+	X, Y, Height int
+}
+
+// Bars lays the weeks out as the bars of a graph ACTIVITY_HEIGHT pixels
+// high. Weeks with commits get at least a pixel.
+func (a Activity) Bars() []ActivityBar {
+	bars := make([]ActivityBar, len(a.Weeks))
+	for i, week := range a.Weeks {
+		height := 0
+		if a.Max > 0 {
+			height = week.Commits * ACTIVITY_HEIGHT / a.Max
+		}
+		if height == 0 && week.Commits > 0 {
+			height = 1
+		}
+		bars[i] = ActivityBar{
+			WeekActivity: week,
+			X:            i * ACTIVITY_BAR_WIDTH,
+			Y:            ACTIVITY_HEIGHT - height,
+			Height:       height,
+		}
+	}
+	return bars
+}
+
+func (a Activity) Width() int {
+	return len(a.Weeks) * ACTIVITY_BAR_WIDTH
+}
+
+func (a Activity) Height() int {
+	return ACTIVITY_HEIGHT
+}
+
+func (a Activity) BarWidth() int {
+	return ACTIVITY_BAR_WIDTH - 1
+}
+
+// weekStart returns the Monday starting the week of t, in UTC.
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// ComputeActivity counts the commits reachable from head in each of the
+// ACTIVITY_WEEKS weeks up to and including the week of now, by committer
+// date. The walk stops at the first commit older than that.
+func ComputeActivity(ctx context.Context, r *git.Repository, head plumbing.Hash, now time.Time) (Activity, error) {
+	last := weekStart(now)
+	first := last.AddDate(0, 0, -7*(ACTIVITY_WEEKS-1))
+	activity := Activity{Weeks: make([]WeekActivity, ACTIVITY_WEEKS)}
+	for i := range activity.Weeks {
+		activity.Weeks[i].Week = first.AddDate(0, 0, 7*i)
+	}
+
+	cIter, err := r.Log(&git.LogOptions{From: head, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return activity, err
+	}
+	defer cIter.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return activity, err
+		}
+		commit, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return activity, err
+		}
+		when := commit.Committer.When
+		if when.Before(first) {
+			break
+		}
+		i := int(weekStart(when).Sub(first).Hours()) / (7 * 24)
+		if i >= len(activity.Weeks) {
+			// Committed in the future, according to its clock.
+			continue
+		}
+		activity.Weeks[i].Commits++
+		activity.Total++
+		if activity.Weeks[i].Commits > activity.Max {
+			activity.Max = activity.Weeks[i].Commits
+		}
+	}
+	return activity, nil
+}
+
+type activityEntry struct {
+	head     plumbing.Hash
+	week     time.Time
+	activity Activity
+}
+
+// ActivityCache keeps the weekly activity of the default branch of each
+// repository, recomputed whenever its head moves or a new week starts.
+type ActivityCache struct {
+	mu      sync.Mutex
+	entries map[string]*activityEntry
+}
+
+func NewActivityCache() *ActivityCache {
+	return &ActivityCache{entries: make(map[string]*activityEntry)}
+}
+
+// Get returns the weekly activity of a repository as of head.
+func (ac *ActivityCache) Get(ctx context.Context, repo RepositoryWithName, head plumbing.Hash) (Activity, error) {
+	now := time.Now()
+	week := weekStart(now)
+	ac.mu.Lock()
+	entry, ok := ac.entries[repo.Name]
+	ac.mu.Unlock()
+	if ok && entry.head == head && entry.week.Equal(week) {
+		return entry.activity, nil
+	}
+	activity, err := ComputeActivity(ctx, repo.Repository, head, now)
+	if err != nil {
+		return activity, err
+	}
+	ac.mu.Lock()
+	ac.entries[repo.Name] = &activityEntry{head: head, week: week, activity: activity}
+	ac.mu.Unlock()
+	return activity, nil
+}
+
+// APIActivity returns the weekly commit counts of the default branch.
+func (sc *Smithy) APIActivity(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	_, revision, err := sc.MainBranch(repo)
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	ctx, cancel := sc.OperationContext(r, "activity")
+	defer cancel()
+	activity, err := sc.activity.Get(ctx, repo, *revision)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.JSON(w, http.StatusOK, activity)
+}
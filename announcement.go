@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Announcement is the Markdown banner shown on every page. The one set by
+// an admin is saved to a file and takes precedence over the -announcement
+// flag.
+type Announcement struct {
+	mu     sync.Mutex
+	path   string
+	text   string
+	loaded bool
+	// source and html are the last rendered announcement.
+	source string
+	html   template.HTML
+}
+
+func NewAnnouncement(path string) *Announcement {
+	return &Announcement{path: path}
+}
+
+func (a *Announcement) load() {
+	if a.loaded {
+		return
+	}
+	a.loaded = true
+	data, err := os.ReadFile(a.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("announcement: %v", err)
+	}
+	a.text = string(data)
+}
+
+// Text returns the announcement set by an admin, if any.
+func (a *Announcement) Text() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.load()
+	return a.text
+}
+
+// HTML renders the announcement set by an admin, or else fallback.
+func (a *Announcement) HTML(fallback string) template.HTML {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.load()
+	text := a.text
+	if strings.TrimSpace(text) == "" {
+		text = fallback
+	}
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	if text != a.source {
+		// Raw HTML is escaped, like in READMEs.
+		a.source, a.html = text, template.HTML(FormatMarkdown(text, "", ""))
+	}
+	return a.html
+}
+
+// Set saves the announcement, or removes it when text is blank.
+func (a *Announcement) Set(text string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loaded = true
+	if strings.TrimSpace(text) == "" {
+		a.text = ""
+		err := os.Remove(a.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.path, []byte(text), 0644); err != nil {
+		return err
+	}
+	a.text = text
+	return nil
+}
+
+// AnnouncementView shows and changes the announcement set by an admin.
+// Posting an empty text falls back to the configured announcement.
+func (sc *Smithy) AnnouncementView(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := sc.banner.Set(r.FormValue("text")); err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		sc.generation.Add(1)
+		sc.pages.Purge()
+		http.Redirect(w, r, "/admin/announcement", http.StatusSeeOther)
+		return
+	}
+	sc.Render(w, r, "announcement", H{
+		"Text":       sc.banner.Text(),
+		"Configured": sc.Config().Announcement,
+	})
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// API_PER_PAGE is the default and API_MAX_PER_PAGE the largest page size of
+// paginated API responses. API_MAX_BLOB_SIZE is the largest file the blob
+// endpoint returns; larger ones are only served raw.
+var (
+	API_PER_PAGE      = 30
+	API_MAX_PER_PAGE  = 100
+	API_MAX_BLOB_SIZE = int64(1 << 20)
+)
+
+type APIRepo struct {
+	Name          string    `json:"name"`
+	Bare          bool      `json:"bare"`
+	DefaultBranch string    `json:"default_branch"`
+	Updated       time.Time `json:"updated"`
+	Size          int64     `json:"size"`
+}
+
+// apiRepoFields are the keys of APIRepo that ?fields= can select.
+var apiRepoFields = []string{"name", "bare", "default_branch", "updated", "size"}
+
+type APISignature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+type APICommit struct {
+	Hash      string       `json:"hash"`
+	Parents   []string     `json:"parents"`
+	Tree      string       `json:"tree"`
+	Author    APISignature `json:"author"`
+	Committer APISignature `json:"committer"`
+	Message   string       `json:"message"`
+}
+
+type APITreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+	Hash string `json:"hash"`
+}
+
+type APIBlob struct {
+	Path     string `json:"path"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+type APIRef struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+}
+
+type APIAsset struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url"`
+}
+
+type APIRelease struct {
+	APIRef
+	Assets []APIAsset `json:"assets"`
+}
+
+// APIPagination reads ?page= and ?per_page= and sets the X-Total-Count and
+// Link headers for a list of total items.
+func (sc *Smithy) APIPagination(w http.ResponseWriter, r *http.Request, total int) Pagination {
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = API_PER_PAGE
+	}
+	if perPage > API_MAX_PER_PAGE {
+		perPage = API_MAX_PER_PAGE
+	}
+	p := NewPagination(total, perPage, GetPage(r))
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	link := func(page int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(page))
+		q.Set("per_page", strconv.Itoa(perPage))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel)
+	}
+	if p.HasNext() {
+		w.Header().Add("Link", link(p.NextPage(), "next"))
+	}
+	if p.HasPrev() {
+		w.Header().Add("Link", link(p.PrevPage(), "prev"))
+	}
+	return p
+}
+
+func (sc *Smithy) APIFindRepo(w http.ResponseWriter, r *http.Request) (RepositoryWithName, bool) {
+	repo, exists := sc.FindRepo(sc.GetParam(r, "repo"))
+	if !exists {
+		sc.JSONError(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+	}
+	return repo, exists
+}
+
+func (sc *Smithy) APIBranches(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	branches, err := ListBranches(repo.Repository)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	p := sc.APIPagination(w, r, len(branches))
+	start, end := p.Bounds()
+	out := []APIRef{}
+	for _, ref := range branches[start:end] {
+		commit, err := repo.Repository.CommitObject(ref.Hash())
+		if err != nil {
+			sc.JSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		out = append(out, APIRef{
+			Name:    ref.Name().Short(),
+			Hash:    commit.Hash.String(),
+			Date:    commit.Committer.When,
+			Message: commit.Message,
+		})
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+func (sc *Smithy) APITags(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	tags, err := ListTagInfos(repo.Repository)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	p := sc.APIPagination(w, r, len(tags))
+	start, end := p.Bounds()
+	out := []APIRef{}
+	for _, tag := range tags[start:end] {
+		out = append(out, APIRef{
+			Name:    tag.Name,
+			Hash:    tag.Commit.Hash.String(),
+			Date:    tag.Date,
+			Message: tag.Message,
+		})
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+// APIReleases lists the tags of a repository like the releases page, with
+// the assets attached to them.
+func (sc *Smithy) APIReleases(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	tags, err := ListTagInfos(repo.Repository)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	SortTagsByVersion(tags)
+
+	p := sc.APIPagination(w, r, len(tags))
+	start, end := p.Bounds()
+	out := []APIRelease{}
+	for _, tag := range tags[start:end] {
+		assets, err := sc.ListReleaseAssets(repo.Name, tag.Name)
+		if err != nil {
+			sc.JSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		release := APIRelease{
+			APIRef: APIRef{
+				Name:    tag.Name,
+				Hash:    tag.Commit.Hash.String(),
+				Date:    tag.Date,
+				Message: tag.Message,
+			},
+			Assets: []APIAsset{},
+		}
+		for _, asset := range assets {
+			release.Assets = append(release.Assets, APIAsset{
+				Name:   asset.Name,
+				Size:   asset.Size,
+				SHA256: asset.SHA256,
+				URL:    fmt.Sprintf("%s/%s/releases/%s/%s", sc.BaseURL(r), repo.Name, tag.Name, asset.Name),
+			})
+		}
+		out = append(out, release)
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+func (sc *Smithy) NewAPIRepo(repo RepositoryWithName) APIRepo {
+	main, revision, _ := sc.MainBranch(repo)
+	out := APIRepo{
+		Name:          repo.Name,
+		Bare:          repo.Bare,
+		DefaultBranch: main,
+	}
+	if revision != nil {
+		if commit, err := repo.Repository.CommitObject(*revision); err == nil {
+			out.Updated = commit.Committer.When
+		}
+	}
+	return out
+}
+
+type repoSummary struct {
+	key        string
+	repo       APIRepo
+	withBranch bool
+	withSize   bool
+}
+
+// RepoSummaryCache keeps what the repository API shows, recomputed
+// whenever the refs or the settings of a repository change.
+type RepoSummaryCache struct {
+	mu      sync.Mutex
+	entries map[string]*repoSummary
+}
+
+func NewRepoSummaryCache() *RepoSummaryCache {
+	return &RepoSummaryCache{entries: make(map[string]*repoSummary)}
+}
+
+// Get returns the listing entry of a known repository. The default branch
+// and last update are filled in when withBranch is set and the size when
+// withSize is; without either the repository is not looked at.
+func (c *RepoSummaryCache) Get(sc *Smithy, known RepositoryWithName, withBranch, withSize bool) (APIRepo, error) {
+	if !withBranch && !withSize {
+		return APIRepo{Name: known.Name, Bare: known.Bare}, nil
+	}
+	repo, err := sc.peekRepo(known)
+	if err != nil {
+		return APIRepo{}, err
+	}
+	key, err := refsKey(repo.Repository)
+	if err != nil {
+		return APIRepo{}, err
+	}
+	// The settings choose the default branch.
+	key += fmt.Sprintf("\x00%#v", sc.RepoSettings(repo))
+
+	c.mu.Lock()
+	entry, ok := c.entries[known.Name]
+	c.mu.Unlock()
+	summary := repoSummary{key: key, repo: APIRepo{Name: known.Name, Bare: known.Bare}}
+	if ok && entry.key == key {
+		if (entry.withBranch || !withBranch) && (entry.withSize || !withSize) {
+			return entry.repo, nil
+		}
+		summary = *entry
+	}
+
+	if withBranch && !summary.withBranch {
+		size := summary.repo.Size
+		summary.repo = sc.NewAPIRepo(repo)
+		summary.repo.Size = size
+		summary.withBranch = true
+	}
+	if withSize && !summary.withSize {
+		summary.repo.Size, _ = DiskUsage(known.Path)
+		summary.withSize = true
+	}
+	c.mu.Lock()
+	c.entries[known.Name] = &summary
+	c.mu.Unlock()
+	return summary.repo, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFields converts v to a JSON object holding only the given keys.
+func selectFields(v any, fields []string) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		out[f] = all[f]
+	}
+	return out, nil
+}
+
+func NewAPISignature(sig object.Signature) APISignature {
+	return APISignature{Name: sig.Name, Email: sig.Email, Date: sig.When}
+}
+
+func NewAPICommit(c *object.Commit) APICommit {
+	out := APICommit{
+		Hash:      c.Hash.String(),
+		Parents:   []string{},
+		Tree:      c.TreeHash.String(),
+		Author:    NewAPISignature(c.Author),
+		Committer: NewAPISignature(c.Committer),
+		Message:   c.Message,
+	}
+	for _, p := range c.ParentHashes {
+		out.Parents = append(out.Parents, p.String())
+	}
+	return out
+}
+
+func entryType(mode filemode.FileMode) string {
+	switch mode {
+	case filemode.Dir:
+		return "tree"
+	case filemode.Submodule:
+		return "commit"
+	case filemode.Symlink:
+		return "symlink"
+	}
+	return "blob"
+}
+
+// APIRepos lists repositories. ?sort= orders them by name (default),
+// updated or size, ?fields= picks the returned keys.
+func (sc *Smithy) APIRepos(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		sc.APIImportRepo(w, r)
+		return
+	}
+	query := r.URL.Query()
+	var fields []string
+	if query.Get("fields") != "" {
+		for _, f := range strings.Split(query.Get("fields"), ",") {
+			f = strings.TrimSpace(f)
+			if !containsString(apiRepoFields, f) {
+				sc.JSONError(w, http.StatusBadRequest, fmt.Errorf("unknown field %q", f))
+				return
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	// Resolving the default branch and walking the repository directories
+	// are the expensive parts, skip them unless their fields are wanted.
+	wanted := func(field string) bool {
+		return fields == nil || containsString(fields, field) || query.Get("sort") == field
+	}
+	withBranch := wanted("default_branch") || wanted("updated")
+	withSize := wanted("size")
+	var repos []APIRepo
+	for _, known := range sc.VisibleRepositories(r) {
+		out, err := sc.summaries.Get(sc, known, withBranch, withSize)
+		if err != nil {
+			log.Printf("listing %s: %v", known.Name, err)
+			continue
+		}
+		repos = append(repos, out)
+	}
+	switch query.Get("sort") {
+	case "", "name":
+	case "updated":
+		sort.SliceStable(repos, func(i, j int) bool { return repos[i].Updated.After(repos[j].Updated) })
+	case "size":
+		sort.SliceStable(repos, func(i, j int) bool { return repos[i].Size > repos[j].Size })
+	default:
+		sc.JSONError(w, http.StatusBadRequest, fmt.Errorf("unknown sort %q", query.Get("sort")))
+		return
+	}
+
+	p := sc.APIPagination(w, r, len(repos))
+	start, end := p.Bounds()
+	out := []any{}
+	for _, repo := range repos[start:end] {
+		if fields == nil {
+			out = append(out, repo)
+			continue
+		}
+		selected, err := selectFields(repo, fields)
+		if err != nil {
+			sc.JSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		out = append(out, selected)
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+func (sc *Smithy) APIRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		sc.APIDeleteRepo(w, r, repo)
+		return
+	}
+	out, err := sc.summaries.Get(sc, repo, true, true)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+func (sc *Smithy) APICommit(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	commit, err := ResolveCommit(repo.Repository, sc.GetParam(r, "hash"))
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	sc.JSON(w, http.StatusOK, NewAPICommit(commit))
+}
+
+func (sc *Smithy) APITree(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	commit, err := ResolveCommit(repo.Repository, sc.GetParam(r, "ref"))
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	treePath := strings.Trim(sc.GetParam(r, "path"), "/")
+	if treePath != "" {
+		tree, err = tree.Tree(treePath)
+		if err != nil {
+			sc.JSONError(w, http.StatusNotFound, err)
+			return
+		}
+	}
+
+	out := []APITreeEntry{}
+	for _, e := range tree.Entries {
+		entryPath := e.Name
+		if treePath != "" {
+			entryPath = treePath + "/" + e.Name
+		}
+		out = append(out, APITreeEntry{
+			Name: e.Name,
+			Path: entryPath,
+			Type: entryType(e.Mode),
+			Mode: e.Mode.String(),
+			Hash: e.Hash.String(),
+		})
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+func (sc *Smithy) APIBlob(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	commit, err := ResolveCommit(repo.Repository, sc.GetParam(r, "ref"))
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	treePath := strings.Trim(sc.GetParam(r, "path"), "/")
+	file, err := commit.File(treePath)
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	if file.Size > API_MAX_BLOB_SIZE {
+		sc.JSONError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("%s is %d bytes, more than %d; download it from %s/%s/raw/%s/%s",
+			treePath, file.Size, API_MAX_BLOB_SIZE, sc.BaseURL(r), repo.Name, commit.Hash, treePath))
+		return
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	out := APIBlob{
+		Path: treePath,
+		Hash: file.Hash.String(),
+		Size: file.Size,
+	}
+	if utf8.Valid(data) {
+		out.Encoding = "utf-8"
+		out.Content = string(data)
+	} else {
+		out.Encoding = "base64"
+		out.Content = base64.StdEncoding.EncodeToString(data)
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
+
+// APIDeleteRepo moves the repository to the trash, see TrashRepository.
+func (sc *Smithy) APIDeleteRepo(w http.ResponseWriter, r *http.Request, repo RepositoryWithName) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if err := sc.TrashRepository(repo); err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
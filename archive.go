@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/groupcache/lru"
+)
+
+// ARCHIVE_DIGEST_CACHE_SIZE is how many archive checksums are remembered.
+var ARCHIVE_DIGEST_CACHE_SIZE = 1024
+
+// ArchiveDigests remembers the checksums of generated archives, keyed by
+// "<repo> <commit> <prefix> <format>". Archives are reproducible, so the
+// digests never go stale; the least recently used ones are evicted.
+type ArchiveDigests struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+var archiveDigests = &ArchiveDigests{cache: lru.New(ARCHIVE_DIGEST_CACHE_SIZE)}
+
+func (d *ArchiveDigests) Get(key string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if v, ok := d.cache.Get(key); ok {
+		return v.(string), true
+	}
+	return "", false
+}
+
+func (d *ArchiveDigests) Add(key, digest string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cache.Add(key, digest)
+}
+
+type archiveFile struct {
+	file    *object.File
+	mode    int64
+	symlink bool
+}
+
+func walkArchive(ctx context.Context, tree *object.Tree, fn func(archiveFile) error) error {
+	return tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		af := archiveFile{file: f, mode: 0644}
+		switch f.Mode {
+		case filemode.Executable:
+			af.mode = 0755
+		case filemode.Symlink:
+			af.mode = 0777
+			af.symlink = true
+		}
+		return fn(af)
+	})
+}
+
+// WriteTarGz writes the tree as a gzipped tarball with every path under
+// prefix. The output only depends on the tree and modTime.
+func WriteTarGz(ctx context.Context, w io.Writer, tree *object.Tree, prefix string, modTime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walkArchive(ctx, tree, func(af archiveFile) error {
+		hdr := &tar.Header{
+			Name:    prefix + af.file.Name,
+			Mode:    af.mode,
+			Size:    af.file.Size,
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		}
+		if af.symlink {
+			target, err := af.file.Contents()
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+			hdr.Size = 0
+			return tw.WriteHeader(hdr)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		reader, err := af.file.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = io.Copy(tw, reader)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// WriteZip writes the tree as a zip file with every path under prefix.
+func WriteZip(ctx context.Context, w io.Writer, tree *object.Tree, prefix string, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(ctx, tree, func(af archiveFile) error {
+		hdr := &zip.FileHeader{
+			Name:     prefix + af.file.Name,
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		mode := fs.FileMode(af.mode)
+		if af.symlink {
+			mode |= fs.ModeSymlink
+		}
+		hdr.SetMode(mode)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		reader, err := af.file.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = io.Copy(fw, reader)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ArchivePrefix is the top-level directory of archives of ref.
+func ArchivePrefix(repoName, refName string) string {
+	return fmt.Sprintf("%s-%s/", strings.TrimSuffix(path.Base(repoName), ".git"), refName)
+}
+
+func WriteArchive(ctx context.Context, w io.Writer, format string, tree *object.Tree, prefix string, modTime time.Time) error {
+	if format == "zip" {
+		return WriteZip(ctx, w, tree, prefix, modTime)
+	}
+	return WriteTarGz(ctx, w, tree, prefix, modTime)
+}
+
+func (sc *Smithy) ArchiveView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	refName := sc.GetParam(r, "ref")
+	format := sc.GetParam(r, "format")
+	revision, err := repo.Repository.ResolveRevision(plumbing.Revision(refName))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	commitObj, err := repo.Repository.CommitObject(*revision)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	tree, err := commitObj.Tree()
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "archive")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	prefix := ArchivePrefix(repoName, refName)
+	filename := strings.TrimSuffix(prefix, "/") + "." + format
+	modTime := commitObj.Committer.When
+
+	if sc.GetParam(r, "sum") != "" {
+		key := fmt.Sprintf("%s %s %s %s", repoName, commitObj.Hash, prefix, format)
+		digest, ok := archiveDigests.Get(key)
+		if !ok {
+			h := sha256.New()
+			if err := WriteArchive(ctx, h, format, tree, prefix, modTime); err != nil {
+				sc.Error(w, http.StatusInternalServerError, err)
+				return
+			}
+			digest = hex.EncodeToString(h.Sum(nil))
+			archiveDigests.Add(key, digest)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s  %s\n", digest, filename)
+		return
+	}
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	// The operation timeout would cut slow downloads short after the
+	// response has started; streaming only stops when the client leaves.
+	if err := WriteArchive(r.Context(), w, format, tree, prefix, modTime); err != nil {
+		// Headers are gone already; all we can do is cut the stream short.
+		log.Printf("archive %s %s: %v", repoName, refName, err)
+	}
+}
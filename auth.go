@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials maps user names to password hashes in htpasswd format.
+// Entries without a recognised hash prefix are compared as plain text.
+type Credentials map[string]string
+
+// LoadHtpasswd adds the users of an htpasswd file. Only bcrypt and {SHA}
+// hashes are supported.
+func (c Credentials) LoadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("%s:%d: malformed entry", path, lineno)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return fmt.Errorf("%s:%d: unsupported hash for %s", path, lineno, user)
+		}
+		c[user] = hash
+	}
+	return scanner.Err()
+}
+
+// Check reports whether password is valid for user.
+func (c Credentials) Check(user, password string) bool {
+	hash, ok := c[user]
+	if !ok {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+	}
+}
+
+// Permission is what a user may do. Each level includes the ones below.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	// PermissionRead grants access to private repositories.
+	PermissionRead
+	PermissionPush
+	PermissionAdmin
+)
+
+func ParsePermission(s string) (Permission, error) {
+	switch s {
+	case "none":
+		return PermissionNone, nil
+	case "read":
+		return PermissionRead, nil
+	case "push":
+		return PermissionPush, nil
+	case "admin":
+		return PermissionAdmin, nil
+	}
+	return PermissionNone, fmt.Errorf("unknown permission %q", s)
+}
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionPush:
+		return "push"
+	case PermissionAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// GroupPermission returns the highest permission mapping grants to any of
+// groups, and read permission when there is none.
+func GroupPermission(mapping StringMap, groups []string) Permission {
+	permission := PermissionRead
+	for _, group := range groups {
+		if p, err := ParsePermission(mapping[group]); err == nil && p > permission {
+			permission = p
+		}
+	}
+	return permission
+}
+
+// AuthEnabled reports whether users are configured at all. Without them
+// everything is open, as it used to be.
+func (sc *Smithy) AuthEnabled() bool {
+	return len(sc.Config().Users) > 0 || sc.Config().OIDC.Enabled() || sc.Config().SAML.Enabled() || sc.Config().ProxyAuth.Enabled() ||
+		sc.Config().AuthHook != ""
+}
+
+// AuthorizePush asks for credentials before a push when users are
+// configured. It reports whether the request may continue.
+func (sc *Smithy) AuthorizePush(w http.ResponseWriter, r *http.Request) bool {
+	return sc.authorize(w, r, PermissionPush)
+}
+
+// AuthorizeAdmin guards administrative actions such as imports and backups.
+func (sc *Smithy) AuthorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	return sc.authorize(w, r, PermissionAdmin)
+}
+
+func (sc *Smithy) authorize(w http.ResponseWriter, r *http.Request, required Permission) bool {
+	if !sc.AuthEnabled() {
+		return true
+	}
+	user, permission := sc.Identify(r)
+	if sc.Config().AuthHook != "" {
+		repo, _ := sc.FindRepo(sc.requestRepoName(r.URL.Path))
+		if sc.AuthHookAllows(user, repo.Name, required) {
+			return true
+		}
+	} else if permission >= required {
+		return true
+	}
+	if user != "" {
+		sc.Error(w, http.StatusForbidden, fmt.Errorf("Permission denied"))
+		return false
+	}
+	sc.RequestAuth(w, r)
+	return false
+}
+
+// RequestAuth asks for credentials: browsers are sent to the login page,
+// other clients get an HTTP Basic challenge.
+func (sc *Smithy) RequestAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && !isGitRequest(r) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="smithy", charset="UTF-8"`)
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
+
+// SiteAuth puts the whole instance behind a single HTTP Basic user when
+// SiteUser is configured. Local users are let through with their own
+// credentials too: clients send only one Authorization header, and they
+// need theirs to push.
+func (sc *Smithy) SiteAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sc.Config().SiteUser == "" || isProbeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		expectedUser, expectedPassword, _ := strings.Cut(sc.Config().SiteUser, ":")
+		user, password, ok := r.BasicAuth()
+		if ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok && sc.Config().Users.Check(user, password) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="smithy", charset="UTF-8"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	})
+}
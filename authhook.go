@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AUTH_HOOK_TIMEOUT limits how long a single auth hook call may take.
+var AUTH_HOOK_TIMEOUT = 5 * time.Second
+
+// AUTH_HOOK_CACHE_TTL is how long answers of the auth hook are reused.
+var AUTH_HOOK_CACHE_TTL = 30 * time.Second
+
+type authHookAnswer struct {
+	allow   bool
+	expires time.Time
+}
+
+// AuthHookCache remembers recent answers of the auth hook so that pages
+// listing many repositories do not call it for each of them every time.
+type AuthHookCache struct {
+	mu      sync.Mutex
+	answers map[string]authHookAnswer
+}
+
+func NewAuthHookCache() *AuthHookCache {
+	return &AuthHookCache{answers: make(map[string]authHookAnswer)}
+}
+
+// AuthHookRequest is what the auth hook is asked about. An empty User is an
+// anonymous request and an empty Repo an action that concerns no single
+// repository, such as an import.
+type AuthHookRequest struct {
+	User   string `json:"user"`
+	Repo   string `json:"repo"`
+	Action string `json:"action"`
+}
+
+// CallAuthHook asks the hook whether req is allowed. An http(s) URL is sent
+// the request as JSON in a POST and allows it by answering with a 2xx
+// status. Anything else is run as a command with SMITHY_USER, SMITHY_REPO
+// and SMITHY_ACTION in its environment and allows it by exiting with 0.
+func CallAuthHook(ctx context.Context, hook string, req AuthHookRequest) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, AUTH_HOOK_TIMEOUT)
+	defer cancel()
+
+	if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return false, err
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+		if err != nil {
+			return false, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return true, nil
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return false, nil
+		}
+		return false, fmt.Errorf("auth hook answered %s", resp.Status)
+	}
+
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Env = append(os.Environ(),
+		"SMITHY_USER="+req.User,
+		"SMITHY_REPO="+req.Repo,
+		"SMITHY_ACTION="+req.Action,
+	)
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AuthHookAllows consults the configured auth hook, denying access when it
+// fails.
+func (sc *Smithy) AuthHookAllows(user, repo string, action Permission) bool {
+	req := AuthHookRequest{User: user, Repo: repo, Action: action.String()}
+	key := sc.Config().AuthHook + "\x00" + req.User + "\x00" + req.Repo + "\x00" + req.Action
+
+	sc.authHook.mu.Lock()
+	answer, ok := sc.authHook.answers[key]
+	sc.authHook.mu.Unlock()
+	if ok && time.Now().Before(answer.expires) {
+		return answer.allow
+	}
+
+	allow, err := CallAuthHook(context.Background(), sc.Config().AuthHook, req)
+	if err != nil {
+		log.Printf("auth hook: %v", err)
+		return false
+	}
+
+	sc.authHook.mu.Lock()
+	defer sc.authHook.mu.Unlock()
+	now := time.Now()
+	for k, a := range sc.authHook.answers {
+		if now.After(a.expires) {
+			delete(sc.authHook.answers, k)
+		}
+	}
+	sc.authHook.answers[key] = authHookAnswer{allow: allow, expires: now.Add(AUTH_HOOK_CACHE_TTL)}
+	return allow
+}
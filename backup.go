@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"time"
+)
+
+// BackupMetadata is the smithy state of a repository that its bundle does
+// not hold. Webhook secrets are left out.
+type BackupMetadata struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Settings are the effective settings, Override those of the config
+	// file alone.
+	Settings    RepoSettings  `json:"settings"`
+	Override    *RepoOverride `json:"override,omitempty"`
+	Stars       []string      `json:"stars"`
+	Watchers    []string      `json:"watchers"`
+	Webhooks    []string      `json:"webhooks"`
+	Digests     []string      `json:"digests"`
+	Aliases     []string      `json:"aliases"`
+	FormerNames []string      `json:"former_names"`
+}
+
+func (sc *Smithy) GetBackupMetadata(repo RepositoryWithName) BackupMetadata {
+	config := sc.Config()
+	meta := BackupMetadata{
+		Name:        repo.Name,
+		CreatedAt:   time.Now(),
+		Settings:    sc.RepoSettings(repo),
+		Stars:       append([]string{}, sc.lists.Users(ListStarred, repo.Name)...),
+		Watchers:    append([]string{}, sc.lists.Users(ListWatching, repo.Name)...),
+		Webhooks:    []string{},
+		Digests:     append([]string{}, config.Digests[repo.Name]...),
+		Aliases:     []string{},
+		FormerNames: sc.transfers.FormerNames(repo.Name),
+	}
+	if override, ok := config.Repos[repo.Name]; ok {
+		meta.Override = &override
+	}
+	for _, hook := range config.Webhooks[repo.Name] {
+		meta.Webhooks = append(meta.Webhooks, hook.URL)
+	}
+	for alias, target := range config.Aliases {
+		if target == repo.Name {
+			meta.Aliases = append(meta.Aliases, alias)
+		}
+	}
+	sort.Strings(meta.Aliases)
+	return meta
+}
+
+// CreateBundle writes a git bundle containing all refs of the repository
+// into a temporary file and returns it, rewound to the start.
+func CreateBundle(ctx context.Context, repo RepositoryWithName) (*os.File, error) {
+	f, err := os.CreateTemp("", "smithy-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", repo.Path, "bundle", "create", "-", "--all")
+	var stderr bytes.Buffer
+	cmd.Stdout = f
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("git bundle: %v: %s", err, stderr.String())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// BackupView streams a tarball with a git bundle of the repository and its
+// smithy metadata. Only admins may take backups.
+func (sc *Smithy) BackupView(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "backup")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	bundle, err := CreateBundle(ctx, repo)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(bundle.Name())
+	defer bundle.Close()
+	stat, err := bundle.Stat()
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	meta, err := json.MarshalIndent(sc.GetBackupMetadata(repo), "", "  ")
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	prefix := fmt.Sprintf("%s-%s", path.Base(repoName), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, prefix))
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := writeTarFile(tw, prefix+"/"+path.Base(repoName)+".bundle", stat.Size(), bundle); err != nil {
+		return
+	}
+	if err := writeTarFile(tw, prefix+"/smithy.json", int64(len(meta)), bytes.NewReader(meta)); err != nil {
+		return
+	}
+	tw.Close()
+	gw.Close()
+}
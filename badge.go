@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// BADGE_COLOR is the background of the value half of badges.
+var BADGE_COLOR = "#4c1"
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[3]d" height="20" role="img" aria-label="%[1]s: %[2]s">
+<title>%[1]s: %[2]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[3]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[4]d" height="20" fill="#555"/><rect x="%[4]d" width="%[5]d" height="20" fill="%[6]s"/><rect width="%[3]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[1]s</text><text x="%[8]d" y="14">%[2]s</text>
+</g>
+</svg>
+`
+
+// RenderBadge draws a shields.io style badge. Text widths are estimated, as
+// there are no font metrics on the server.
+func RenderBadge(label, value, color string) string {
+	labelWidth := 10 + 7*utf8.RuneCountInString(label)
+	valueWidth := 10 + 7*utf8.RuneCountInString(value)
+	return fmt.Sprintf(badgeTemplate,
+		html.EscapeString(label), html.EscapeString(value),
+		labelWidth+valueWidth, labelWidth, valueWidth, color,
+		labelWidth/2, labelWidth+valueWidth/2)
+}
+
+// BadgeView serves /{repo}/badge/{kind}.svg for the kinds "commits",
+// "tag" and "clones".
+func (sc *Smithy) BadgeView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	var label, value string
+	switch sc.GetParam(r, "kind") {
+	case "commits":
+		ctx, cancel := sc.OperationContext(r, "stats")
+		defer cancel()
+		_, revision, err := sc.MainBranch(repo)
+		if err != nil {
+			sc.Error(w, http.StatusNotFound, err)
+			return
+		}
+		commits, err := CountCommits(ctx, repo, *revision)
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		label, value = "commits", strconv.Itoa(commits)
+	case "clones":
+		label, value = "clones", strconv.Itoa(sc.clones.Count(repo.Name))
+	case "tag":
+		tags, err := ListTagInfos(repo.Repository)
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		label, value = "tag", "none"
+		if len(tags) > 0 {
+			value = tags[0].Name
+		}
+	default:
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Unknown badge"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	fmt.Fprint(w, RenderBadge(label, value, BADGE_COLOR))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type BlameLine struct {
+	Number int
+	Text   string
+}
+
+// BlameHunk is a run of consecutive lines last changed by the same commit.
+type BlameHunk struct {
+	Hash   plumbing.Hash
+	Author string
+	Date   time.Time
+	Lines  []BlameLine
+	// Abbrev is how many hex digits ShortHash keeps, see AbbrevLength.
+	Abbrev int
+}
+
+func (h BlameHunk) ShortHash() string {
+	return Abbreviate(h.Hash, h.Abbrev)
+}
+
+// BlameFile annotates every line of the file with the commit that last
+// changed it. go-git cannot interrupt a blame, so a cancelled context only
+// stops the caller from waiting for the result; release is called once the
+// blame has actually finished, so that the heavy slot it holds stays taken
+// until then.
+func BlameFile(ctx context.Context, commit *object.Commit, path string, release func()) ([]BlameHunk, error) {
+	type result struct {
+		blame *git.BlameResult
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer release()
+		blame, err := git.Blame(commit, path)
+		done <- result{blame, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	var hunks []BlameHunk
+	for i, line := range res.blame.Lines {
+		if len(hunks) == 0 || hunks[len(hunks)-1].Hash != line.Hash {
+			hunks = append(hunks, BlameHunk{
+				Hash:   line.Hash,
+				Author: line.AuthorName,
+				Date:   line.Date,
+			})
+		}
+		hunk := &hunks[len(hunks)-1]
+		hunk.Lines = append(hunk.Lines, BlameLine{Number: i + 1, Text: line.Text})
+	}
+	return hunks, nil
+}
+
+func (sc *Smithy) BlameView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	refName := sc.GetParam(r, "ref")
+	treePath := sc.GetParam(r, "path")
+	revision, err := repo.Repository.ResolveRevision(plumbing.Revision(refName))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	commitObj, err := repo.Repository.CommitObject(*revision)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	file, err := commitObj.File(treePath)
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "blame")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	hunks, err := BlameFile(ctx, commitObj, treePath, release)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	abbrev := AbbrevLength(repo.Repository)
+	for i := range hunks {
+		hunks[i].Abbrev = abbrev
+	}
+
+	sc.Render(w, r, "blame", H{
+		"RepoName":   repoName,
+		"RefName":    refName,
+		"File":       file,
+		"ParentPath": filepath.Dir(treePath),
+		"Path":       treePath,
+		"Hunks":      hunks,
+	})
+}
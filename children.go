@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// CHILD_MAP_DEPTH is how many of the most recent commits are indexed when
+// looking up the children of a commit.
+var CHILD_MAP_DEPTH = 5000
+
+type childMap struct {
+	refsKey  string
+	children map[plumbing.Hash][]plumbing.Hash
+}
+
+// ChildCache keeps a parent -> children index of the recent history of each
+// repository, rebuilt whenever its refs move.
+type ChildCache struct {
+	mu   sync.Mutex
+	maps map[string]*childMap
+}
+
+func NewChildCache() *ChildCache {
+	return &ChildCache{maps: make(map[string]*childMap)}
+}
+
+func refsKey(r *git.Repository) (string, error) {
+	it, err := r.References()
+	if err != nil {
+		return "", err
+	}
+	refs, err := ReferenceCollector(it)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, ref := range refs {
+		sb.WriteString(ref.Name().String())
+		sb.WriteByte(' ')
+		sb.WriteString(ref.Hash().String())
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+func buildChildMap(r *git.Repository) (map[plumbing.Hash][]plumbing.Hash, error) {
+	children := make(map[plumbing.Hash][]plumbing.Hash)
+	cIter, err := r.Log(&git.LogOptions{All: true, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer cIter.Close()
+	for i := 0; i < CHILD_MAP_DEPTH; i++ {
+		commit, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range commit.ParentHashes {
+			children[parent] = append(children[parent], commit.Hash)
+		}
+	}
+	return children, nil
+}
+
+// Children returns the known children of the commit within the indexed
+// recent history of the repository.
+func (cc *ChildCache) Children(repo RepositoryWithName, hash plumbing.Hash) ([]plumbing.Hash, error) {
+	key, err := refsKey(repo.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cm, ok := cc.maps[repo.Name]
+	if !ok || cm.refsKey != key {
+		children, err := buildChildMap(repo.Repository)
+		if err != nil {
+			return nil, err
+		}
+		cm = &childMap{refsKey: key, children: children}
+		cc.maps[repo.Name] = cm
+	}
+	return cm.children[hash], nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CloneCounter counts anonymous clones and fetches per repository,
+// persisted as JSON so counts survive restarts.
+type CloneCounter struct {
+	mu     sync.Mutex
+	path   string
+	counts map[string]int
+}
+
+func NewCloneCounter(path string) *CloneCounter {
+	return &CloneCounter{path: path}
+}
+
+func (cc *CloneCounter) load() {
+	if cc.counts != nil {
+		return
+	}
+	cc.counts = make(map[string]int)
+	data, err := os.ReadFile(cc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &cc.counts)
+	}
+	if err != nil {
+		log.Printf("clone counts: %v", err)
+	}
+}
+
+func (cc *CloneCounter) Increment(repoName string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.load()
+	cc.counts[repoName]++
+	if err := cc.save(); err != nil {
+		log.Printf("clone counts: %v", err)
+	}
+}
+
+func (cc *CloneCounter) save() error {
+	data, err := json.Marshal(cc.counts)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cc.path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(cc.path, data, 0644)
+	}
+	return err
+}
+
+// Rename moves the count of a repository to its new name.
+func (cc *CloneCounter) Rename(old, new string) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.load()
+	if _, ok := cc.counts[old]; !ok {
+		return nil
+	}
+	cc.counts[new] += cc.counts[old]
+	delete(cc.counts, old)
+	return cc.save()
+}
+
+func (cc *CloneCounter) Count(repoName string) int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.load()
+	return cc.counts[repoName]
+}
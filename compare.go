@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// COMPARE_WALK_LIMIT is how much of the merge base's history is excluded
+// from the commits of a comparison.
+var COMPARE_WALK_LIMIT = 20000
+
+// Comparison is what a head revision adds on top of a base, as in
+// `git log base..head` and `git diff base...head`.
+type Comparison struct {
+	Base      *object.Commit
+	Head      *object.Commit
+	MergeBase *object.Commit
+	// Commits are newest first. Truncated is set when there were more than
+	// the limit.
+	Commits   []Commit
+	Truncated bool
+	Changes   object.Changes
+}
+
+// Compare finds the merge base of base and head, the commits of head since
+// then and the changes between the merge base and head. Hashes are
+// abbreviated to abbrev hex digits.
+func Compare(ctx context.Context, base, head *object.Commit, limit, abbrev int) (*Comparison, error) {
+	bases, err := base.MergeBase(head)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("%s and %s have no common history", base.Hash, head.Hash)
+	}
+	cmp := &Comparison{Base: base, Head: head, MergeBase: bases[0]}
+
+	// Skipping only the merge bases would let the walk reach their history
+	// through branches forked before them.
+	seen, err := ancestors(ctx, bases, COMPARE_WALK_LIMIT)
+	if err != nil {
+		return nil, err
+	}
+	cIter := object.NewCommitIterCTime(head, seen, nil)
+	defer cIter.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(cmp.Commits) >= limit {
+			cmp.Truncated = true
+			break
+		}
+		cmp.Commits = append(cmp.Commits, NewCommit(commit, abbrev))
+	}
+
+	from, err := cmp.MergeBase.Tree()
+	if err != nil {
+		return nil, err
+	}
+	to, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	cmp.Changes, err = object.DiffTreeContext(ctx, from, to)
+	return cmp, contextError(ctx, err)
+}
+
+// ancestors returns the commits reachable from commits, themselves
+// included, stopping once limit commits have been visited.
+func ancestors(ctx context.Context, commits []*object.Commit, limit int) (map[plumbing.Hash]bool, error) {
+	seen := make(map[plumbing.Hash]bool)
+	for _, c := range commits {
+		cIter := object.NewCommitIterBSF(c, seen, nil)
+		for len(seen) < limit {
+			if err := ctx.Err(); err != nil {
+				cIter.Close()
+				return nil, err
+			}
+			commit, err := cIter.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cIter.Close()
+				return nil, err
+			}
+			seen[commit.Hash] = true
+		}
+		cIter.Close()
+	}
+	return seen, nil
+}
+
+// CompareView shows what head adds on top of base. Without revisions it
+// offers a form to pick them.
+func (sc *Smithy) CompareView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	baseRev, headRev := sc.GetParam(r, "base"), sc.GetParam(r, "head")
+	if baseRev == "" || headRev == "" {
+		query := r.URL.Query()
+		if query.Get("base") != "" && query.Get("head") != "" {
+			location := fmt.Sprintf("/%s/compare/%s...%s", repo.Name,
+				url.PathEscape(query.Get("base")), url.PathEscape(query.Get("head")))
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		branches, _ := ListBranches(repo.Repository)
+		tags, _ := ListTags(repo.Repository)
+		main, _, _ := sc.MainBranch(repo)
+		sc.Render(w, r, "compare", H{
+			"RepoName": repoName,
+			"Repo":     repo,
+			"Branches": branches,
+			"Tags":     tags,
+			"BaseRef":  main,
+		})
+		return
+	}
+
+	base, err := ResolveCommit(repo.Repository, baseRev)
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("%s: %v", baseRev, err))
+		return
+	}
+	head, err := ResolveCommit(repo.Repository, headRev)
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("%s: %v", headRev, err))
+		return
+	}
+	immutable := base.Hash.String() == baseRev && head.Hash.String() == headRev
+	if sc.NotModified(w, r, immutable, "compare", base.Hash.String(), head.Hash.String()) {
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "diff")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	cmp, err := Compare(ctx, base, head, sc.HistoryLimits(r).RangeSize, AbbrevLength(repo.Repository))
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	formattedChanges, err := FormatChanges(ctx, cmp.Changes)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	sc.Render(w, r, "compare", H{
+		"RepoName":   repoName,
+		"Repo":       repo,
+		"BaseRef":    baseRev,
+		"HeadRef":    headRev,
+		"Comparison": cmp,
+		"Changes":    template.HTML(formattedChanges),
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressWriter compresses the response when its content type is one of
+// types, deciding when the header is written.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	types    []string
+	w        io.WriteCloser
+	decided  bool
+}
+
+func (cw *compressWriter) compressible(status int) bool {
+	h := cw.Header()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return containsString(cw.types, mediaType)
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.decided = true
+	h := cw.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if cw.compressible(status) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// The compressed body is a different representation.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "zstd" {
+			cw.w, _ = zstd.NewWriter(cw.ResponseWriter, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		} else {
+			cw.w = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w != nil {
+		return cw.w.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Close() error {
+	if cw.w != nil {
+		return cw.w.Close()
+	}
+	return nil
+}
+
+// acceptedEncoding picks zstd or gzip from the request's Accept-Encoding,
+// preferring zstd, or returns "".
+func acceptedEncoding(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	for _, encoding := range []string{"zstd", "gzip"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// Compress compresses responses whose content type is listed in
+// CompressTypes for clients that accept zstd or gzip. Packs, archives and
+// other binary downloads are left alone.
+func (sc *Smithy) Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead || len(sc.Config().CompressTypes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, types: sc.Config().CompressTypes}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout limits git operations that have no entry in
+// SmithyConfig.Timeouts.
+const DefaultTimeout = 30 * time.Second
+
+// OperationTimeouts are the limits of operations that take longer than
+// DefaultTimeout with any real-sized repository, unless Timeouts sets them.
+var OperationTimeouts = map[string]time.Duration{
+	"import": time.Hour,
+	"backup": time.Hour,
+}
+
+type SmithyConfig struct {
+	// Title and Description describe the instance in page headers.
+	Title       string
+	Description string
+	// Announcement is Markdown shown as a banner on every page, unless an
+	// admin set another one.
+	Announcement string
+	// Host is the public host name used in clone URLs.
+	Host string
+	// ExternalURL, e.g. https://code.example.org:8443, is how clients reach
+	// smithy when that differs from the listen address, such as behind a
+	// proxy. It is used for clone URLs, go-import tags and links in feeds
+	// and webhooks, and takes precedence over Host.
+	ExternalURL string
+	Root        string
+	Port        string
+	// Listen overrides Port with a TCP address or "unix:/path/to.sock".
+	// Sockets passed by systemd take precedence over both.
+	Listen string
+	// GitDaemonAddr enables read-only git:// access on the given address,
+	// usually ":9418".
+	GitDaemonAddr string
+	// UploadPackRate and UploadPackGlobalRate cap the bandwidth of clone and
+	// fetch responses in KiB/s, per connection and for all connections
+	// together. Zero means unlimited.
+	UploadPackRate       int64
+	UploadPackGlobalRate int64
+	// RateLimit caps the requests each client IP may send per minute.
+	// Zero means unlimited.
+	RateLimit RateLimitConfig
+	// CompressTypes lists the content types of responses compressed for
+	// clients accepting zstd or gzip.
+	CompressTypes StringList
+	// PageCacheTTL is how long the repository index, repository front
+	// pages and refs pages are cached for anonymous visitors. Zero disables
+	// the cache.
+	PageCacheTTL time.Duration
+	// UserLimits and AnonymousLimits cap how deep into history logged in
+	// users and anonymous visitors may browse.
+	UserLimits      HistoryLimits
+	AnonymousLimits HistoryLimits
+	// HighlightMaxLines is the largest file, in lines, that is syntax
+	// highlighted. Larger files are shown as plain text.
+	HighlightMaxLines int
+	// Locale formats dates and numbers for visitors whose languages are
+	// not in LOCALE_FORMATS.
+	Locale string
+	// MaxRenderSize is how many KiB of a file blob pages show at most.
+	// Larger files are cut short and can be downloaded raw.
+	MaxRenderSize int64
+	// StaticDir holds files served under /static/, such as a logo.svg
+	// replacing the logo and a custom.css added to every page.
+	StaticDir string
+	// DataDir holds smithy's own files such as release assets. It defaults
+	// to ".smithy" inside Root.
+	DataDir string
+	// MaxAssetSize limits the size of uploaded release assets in MiB.
+	MaxAssetSize int64
+	// AllowNonBarePush enables git-receive-pack for working-copy
+	// repositories. Pushing into a checked out branch is refused by git
+	// anyway, so this is off by default.
+	AllowNonBarePush bool
+	// MaxHeavyOperations caps how many expensive operations (diffs,
+	// archives, blame, ...) run at once. Further requests queue until a slot
+	// frees up or their timeout expires.
+	MaxHeavyOperations int
+	// ObjectCacheSize is the size in MiB of the go-git object cache of each
+	// repository, or of the single cache shared by all repositories when
+	// SharedObjectCache is set.
+	ObjectCacheSize   int
+	SharedObjectCache bool
+	// ReplaceRefs makes history and diffs follow refs/replace/* and
+	// info/grafts, like git does.
+	ReplaceRefs bool
+	// Watch rescans Root as soon as repositories are added or removed.
+	Watch bool
+	// MaxOpenRepos caps how many repositories are kept open at once.
+	MaxOpenRepos int
+	// RenderCacheSize is the size in MiB of the cache of rendered READMEs
+	// and highlighted blobs.
+	RenderCacheSize int
+	// SearchIndex keeps a search index of the default branches, updated
+	// on push, instead of searching repositories file by file.
+	SearchIndex bool
+	// Aliases maps alternative and former repository names to the
+	// repository they refer to. Requests using an alias are redirected.
+	Aliases StringMap
+	// Users can log in. When there are any, only they may push over
+	// HTTP, use the admin actions and see private repositories.
+	Users Credentials
+	// TLS enables HTTPS.
+	TLS TLSConfig
+	// Server tunes timeouts, header limits and HTTP/2 of the web server.
+	Server ServerConfig
+	// AuthHook, a command or an http(s) URL, decides who may read, push
+	// to and administer repositories instead of the permissions above.
+	AuthHook string
+	// SiteUser, as "user:password", is required for every HTTP request,
+	// unless the request carries the credentials of one of the Users.
+	SiteUser string
+	// OIDC delegates logins to an OpenID Connect provider.
+	OIDC OIDCConfig
+	// SAML delegates logins to a SAML 2.0 identity provider.
+	SAML SAMLConfig
+	// ProxyAuth takes the user from a header set by a reverse proxy.
+	ProxyAuth ProxyAuthConfig
+	// TrashRetention is how long deleted repositories are kept before
+	// they are purged.
+	TrashRetention time.Duration
+	// Webhooks lists the URLs notified of pushes, per repository.
+	Webhooks WebhookMap
+	// Mail configures the mail server notifications are sent through.
+	Mail MailConfig
+	// Digests lists who receives periodic summaries of the activity of
+	// which repositories, every DigestPeriod.
+	Digests      DigestMap
+	DigestPeriod time.Duration
+	// LinkCheckPeriod is how often the websites and mirror remotes of
+	// repositories are checked, or 0 not to check them.
+	LinkCheckPeriod time.Duration
+	// Repos overrides settings of individual repositories.
+	Repos map[string]RepoOverride
+	// Profiles describes users on their profile pages.
+	Profiles map[string]UserProfile
+	// Timeouts limits how long a single operation ("log", "diff",
+	// "backup", ...) may run on behalf of a request. The "default" key
+	// overrides DefaultTimeout.
+	Timeouts DurationMap
+	// ReadmeNames, HiddenRefs, MarkdownExtensions and HashLength set
+	// README_NAMES, HIDDEN_REFS, MARKDOWN_EXTENSIONS and HASH_LENGTH when
+	// smithy starts.
+	ReadmeNames        []string
+	HiddenRefs         []string
+	MarkdownExtensions []string
+	HashLength         int
+}
+
+// ApplyGlobals sets the package settings the configuration overrides. It
+// must only be called before serving requests.
+func (c SmithyConfig) ApplyGlobals() {
+	README_NAMES = c.ReadmeNames
+	HIDDEN_REFS = c.HiddenRefs
+	MARKDOWN_EXTENSIONS = c.MarkdownExtensions
+	HASH_LENGTH = c.HashLength
+}
+
+// Clone returns a copy of c whose per-repository maps can be changed
+// without affecting c.
+func (c SmithyConfig) Clone() *SmithyConfig {
+	aliases := make(StringMap, len(c.Aliases))
+	for alias, target := range c.Aliases {
+		aliases[alias] = target
+	}
+	c.Aliases = aliases
+	repos := make(map[string]RepoOverride, len(c.Repos))
+	for name, override := range c.Repos {
+		repos[name] = override
+	}
+	c.Repos = repos
+	webhooks := make(WebhookMap, len(c.Webhooks))
+	for name, hooks := range c.Webhooks {
+		webhooks[name] = append([]WebhookConfig(nil), hooks...)
+	}
+	c.Webhooks = webhooks
+	digests := make(DigestMap, len(c.Digests))
+	for name, addresses := range c.Digests {
+		digests[name] = append([]string(nil), addresses...)
+	}
+	c.Digests = digests
+	return &c
+}
+
+// DataPath joins elem onto the data directory.
+func (c SmithyConfig) DataPath(elem ...string) string {
+	dir := c.DataDir
+	if dir == "" {
+		dir = filepath.Join(c.Root, ".smithy")
+	}
+	return filepath.Join(append([]string{dir}, elem...)...)
+}
+
+// CloneURL returns the URL repositories are cloned from, without the
+// repository name.
+func (c SmithyConfig) CloneURL() string {
+	if c.ExternalURL != "" {
+		return strings.TrimSuffix(c.ExternalURL, "/")
+	}
+	return "https://" + c.Host
+}
+
+// Timeout returns the time limit for the named operation.
+func (c SmithyConfig) Timeout(op string) time.Duration {
+	if d, ok := c.Timeouts[op]; ok {
+		return d
+	}
+	if d, ok := OperationTimeouts[op]; ok {
+		return d
+	}
+	if d, ok := c.Timeouts["default"]; ok {
+		return d
+	}
+	return DefaultTimeout
+}
+
+// DurationMap is a flag.Value accepting "name=duration,name=duration".
+type DurationMap map[string]time.Duration
+
+func (m DurationMap) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m DurationMap) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("expected name=duration, got %q", pair)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		m[strings.TrimSpace(name)] = d
+	}
+	return nil
+}
+
+// StringMap is a flag.Value accepting "key=value,key=value".
+type StringMap map[string]string
+
+func (m StringMap) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m StringMap) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return nil
+}
+
+// StringList is a flag.Value accepting "a,b,c", repeatable.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoOverride holds per-repository settings from the config file, or from
+// a repository's .smithy.yml. Those of the config file take precedence over
+// the repository's own.
+type RepoOverride struct {
+	Landing       *string  `yaml:"landing"`
+	Readme        []string `yaml:"readme"`
+	Private       *bool    `yaml:"private"`
+	Issues        *string  `yaml:"issues"`
+	Description   *string  `yaml:"description"`
+	DefaultBranch *string  `yaml:"default_branch"`
+	Website       *string  `yaml:"website"`
+	Hidden        *bool    `yaml:"hidden"`
+	CloneURLs     []string `yaml:"clone_urls"`
+	Notice        *string  `yaml:"notice"`
+}
+
+// LoadConfigFile applies a YAML config file to the flags of fs. Keys are
+// flag names; lists and mappings are passed to repeatable and key=value
+// flags one item at a time. Flags given on the command line win over the
+// file. The "repos" key holds per-repository overrides and the "users" key
+// user profiles.
+func LoadConfigFile(fs *flag.FlagSet, path string, repos map[string]RepoOverride, users map[string]UserProfile) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of settings", path, root.Line)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value == "repos" {
+			if err := decodeRepos(value, repos); err != nil {
+				return fmt.Errorf("%s:%v", path, err)
+			}
+			continue
+		}
+		if key.Value == "users" {
+			if err := decodeUsers(value, users); err != nil {
+				return fmt.Errorf("%s:%v", path, err)
+			}
+			continue
+		}
+		f := fs.Lookup(key.Value)
+		if f == nil || key.Value == "config" {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, key.Line, key.Value)
+		}
+		if explicit[key.Value] {
+			continue
+		}
+		if err := setFlagFromNode(f, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, value.Line, key.Value, err)
+		}
+	}
+	return nil
+}
+
+func setFlagFromNode(f *flag.Flag, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return f.Value.Set(node.Value)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: expected a plain value", item.Line)
+			}
+			if err := f.Value.Set(item.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if v.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: expected a plain value", v.Line)
+			}
+			if err := f.Value.Set(k.Value + "=" + v.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported value")
+}
+
+// decodeSettings checks that node maps names to mappings of the fields of
+// t, rejecting unknown fields with their line, and calls decode for each.
+func decodeSettings(node *yaml.Node, what string, t reflect.Type, decode func(name string, value *yaml.Node) error) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%d: expected a mapping of %s", node.Line, what)
+	}
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		known[t.Field(i).Tag.Get("yaml")] = true
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.MappingNode {
+			return fmt.Errorf("%d: %s: expected a mapping of settings", value.Line, name.Value)
+		}
+		for j := 0; j < len(value.Content); j += 2 {
+			if key := value.Content[j]; !known[key.Value] {
+				return fmt.Errorf("%d: %s: unknown setting %q", key.Line, name.Value, key.Value)
+			}
+		}
+		if err := decode(name.Value, value); err != nil {
+			return fmt.Errorf("%d: %s: %v", value.Line, name.Value, err)
+		}
+	}
+	return nil
+}
+
+// decodeRepos decodes the per-repository overrides.
+func decodeRepos(node *yaml.Node, repos map[string]RepoOverride) error {
+	return decodeSettings(node, "repositories", reflect.TypeOf(RepoOverride{}), func(name string, value *yaml.Node) error {
+		var override RepoOverride
+		if err := value.Decode(&override); err != nil {
+			return err
+		}
+		repos[name] = override
+		return nil
+	})
+}
+
+// decodeUsers decodes the user profiles.
+func decodeUsers(node *yaml.Node, users map[string]UserProfile) error {
+	return decodeSettings(node, "users", reflect.TypeOf(UserProfile{}), func(name string, value *yaml.Node) error {
+		var profile UserProfile
+		if err := value.Decode(&profile); err != nil {
+			return err
+		}
+		users[name] = profile
+		return nil
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/groupcache/lru"
+)
+
+var (
+	// CONTAINS_WALK_LIMIT is how many commits are visited from all refs
+	// together before giving up on finding the commit in their history.
+	CONTAINS_WALK_LIMIT = 20000
+	// CONTAINS_CACHE_SIZE is how many commits' containing refs are
+	// remembered.
+	CONTAINS_CACHE_SIZE = 1024
+)
+
+// Containment lists the refs whose history includes a commit.
+type Containment struct {
+	Branches []string
+	Tags     []string
+	// NearestTag is the oldest tag containing the commit, i.e. the first
+	// release it shipped in.
+	NearestTag string
+	// Incomplete is set when the walk stopped early, at the time or commit
+	// limit, so refs may be missing.
+	Incomplete bool
+}
+
+type containsKey struct {
+	repo string
+	hash plumbing.Hash
+}
+
+type containsEntry struct {
+	refsKey string
+	result  Containment
+}
+
+// ContainsCache remembers which branches and tags contain the most recently
+// viewed commits, as long as the refs of their repository stay put.
+type ContainsCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func NewContainsCache() *ContainsCache {
+	return &ContainsCache{cache: lru.New(CONTAINS_CACHE_SIZE)}
+}
+
+// CONTAINS_DATE_SLOP is how much older than the commit a parent may be and
+// still be walked, to allow for skewed committer clocks.
+var CONTAINS_DATE_SLOP = 24 * time.Hour
+
+// descendants walks back from the tips once, visiting at most limit commits
+// and none committed well before target, and returns the set of visited
+// commits that have target in their history. It reports whether the walk
+// finished before the limit.
+func descendants(ctx context.Context, r *git.Repository, tips []plumbing.Hash, target *object.Commit, limit int) (map[plumbing.Hash]bool, bool, error) {
+	cutoff := target.Committer.When.Add(-CONTAINS_DATE_SLOP)
+	children := make(map[plumbing.Hash][]plumbing.Hash)
+	seen := make(map[plumbing.Hash]bool)
+	var queue []plumbing.Hash
+	for _, tip := range tips {
+		if !seen[tip] {
+			seen[tip] = true
+			queue = append(queue, tip)
+		}
+	}
+	for len(queue) > 0 && len(seen) <= limit {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		hash := queue[0]
+		queue = queue[1:]
+		if hash == target.Hash {
+			continue
+		}
+		commit, err := r.CommitObject(hash)
+		if err != nil {
+			return nil, false, err
+		}
+		if commit.Committer.When.Before(cutoff) {
+			continue
+		}
+		for _, parent := range commit.ParentHashes {
+			children[parent] = append(children[parent], hash)
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	complete := len(queue) == 0
+	result := map[plumbing.Hash]bool{target.Hash: true}
+	queue = []plumbing.Hash{target.Hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		for _, child := range children[hash] {
+			if !result[child] {
+				result[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return result, complete, nil
+}
+
+func computeContainment(ctx context.Context, r *git.Repository, commit *object.Commit) (Containment, error) {
+	var c Containment
+
+	branches, err := ListBranches(r)
+	if err != nil {
+		return c, err
+	}
+	tags, err := ListTags(r)
+	if err != nil {
+		return c, err
+	}
+	var infos []TagInfo
+	var tips []plumbing.Hash
+	for _, ref := range branches {
+		tips = append(tips, ref.Hash())
+	}
+	for _, ref := range tags {
+		info, err := GetTagInfo(r, ref)
+		if err != nil || info.Commit == nil {
+			continue
+		}
+		infos = append(infos, info)
+		tips = append(tips, info.Commit.Hash)
+	}
+
+	reached, complete, err := descendants(ctx, r, tips, commit, CONTAINS_WALK_LIMIT)
+	if err != nil {
+		return c, err
+	}
+	c.Incomplete = !complete
+
+	for _, ref := range branches {
+		if reached[ref.Hash()] {
+			c.Branches = append(c.Branches, ref.Name().Short())
+		}
+	}
+	var nearest *object.Commit
+	for _, info := range infos {
+		if !reached[info.Commit.Hash] {
+			continue
+		}
+		c.Tags = append(c.Tags, info.Name)
+		if nearest == nil || info.Commit.Committer.When.Before(nearest.Committer.When) {
+			nearest = info.Commit
+			c.NearestTag = info.Name
+		}
+	}
+	sort.Strings(c.Branches)
+	sort.Strings(c.Tags)
+	return c, nil
+}
+
+// Contains returns the branches and tags whose history includes the commit.
+func (cc *ContainsCache) Contains(ctx context.Context, repo RepositoryWithName, commit *object.Commit) (Containment, error) {
+	key, err := refsKey(repo.Repository)
+	if err != nil {
+		return Containment{}, err
+	}
+
+	ck := containsKey{repo: repo.Name, hash: commit.Hash}
+	cc.mu.Lock()
+	cached, ok := cc.cache.Get(ck)
+	cc.mu.Unlock()
+	if ok && cached.(containsEntry).refsKey == key {
+		return cached.(containsEntry).result, nil
+	}
+
+	// A walk that ran out of time is remembered as incomplete, so that
+	// reloading the page does not repeat it.
+	c, err := computeContainment(ctx, repo.Repository, commit)
+	if errors.Is(err, context.DeadlineExceeded) {
+		c = Containment{Incomplete: true}
+	} else if err != nil {
+		return c, err
+	}
+
+	cc.mu.Lock()
+	cc.cache.Add(ck, containsEntry{refsKey: key, result: c})
+	cc.mu.Unlock()
+	return c, err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// AVATAR_URL is where avatars are fetched from, given the hex SHA-256 of a
+// lowercase email address.
+var AVATAR_URL = "https://www.gravatar.com/avatar/%s?s=40&d=identicon"
+
+// Contributor sums up the commits of an author, identified by email.
+type Contributor struct {
+	Name    string
+	Email   string
+	Commits int
+	First   time.Time
+	Last    time.Time
+}
+
+// Avatar returns the URL of the contributor's avatar.
+func (c Contributor) Avatar() string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(c.Email))))
+	return fmt.Sprintf(AVATAR_URL, fmt.Sprintf("%x", sum))
+}
+
+type contributorsEntry struct {
+	head         plumbing.Hash
+	contributors []Contributor
+}
+
+// ContributorCache keeps the contributors of the default branch of each
+// repository, recomputed whenever its head moves.
+type ContributorCache struct {
+	mu      sync.Mutex
+	entries map[string]*contributorsEntry
+}
+
+func NewContributorCache() *ContributorCache {
+	return &ContributorCache{entries: make(map[string]*contributorsEntry)}
+}
+
+// ListContributors walks the history reachable from head and returns its
+// authors, the most active first. Authors are told apart by their email
+// and named after their latest commit.
+func ListContributors(ctx context.Context, r *git.Repository, head plumbing.Hash) ([]Contributor, error) {
+	cIter, err := r.Log(&git.LogOptions{From: head, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer cIter.Close()
+	byEmail := make(map[string]*Contributor)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		author := commit.Author
+		key := strings.ToLower(author.Email)
+		c, ok := byEmail[key]
+		if !ok {
+			c = &Contributor{Name: author.Name, Email: author.Email, First: author.When, Last: author.When}
+			byEmail[key] = c
+		}
+		c.Commits++
+		if author.When.Before(c.First) {
+			c.First = author.When
+		}
+		if author.When.After(c.Last) {
+			c.Last = author.When
+		}
+	}
+	contributors := make([]Contributor, 0, len(byEmail))
+	for _, c := range byEmail {
+		contributors = append(contributors, *c)
+	}
+	sort.Slice(contributors, func(i, j int) bool {
+		if contributors[i].Commits != contributors[j].Commits {
+			return contributors[i].Commits > contributors[j].Commits
+		}
+		return contributors[i].Email < contributors[j].Email
+	})
+	return contributors, nil
+}
+
+// Get returns the contributors of a repository as of head, computing them
+// if head moved since the last call.
+func (cc *ContributorCache) Get(ctx context.Context, repo RepositoryWithName, head plumbing.Hash) ([]Contributor, error) {
+	cc.mu.Lock()
+	entry, ok := cc.entries[repo.Name]
+	cc.mu.Unlock()
+	if ok && entry.head == head {
+		return entry.contributors, nil
+	}
+	contributors, err := ListContributors(ctx, repo.Repository, head)
+	if err != nil {
+		return nil, err
+	}
+	cc.mu.Lock()
+	cc.entries[repo.Name] = &contributorsEntry{head: head, contributors: contributors}
+	cc.mu.Unlock()
+	return contributors, nil
+}
+
+// ContributorsView lists who committed to the default branch, how often and
+// when.
+func (sc *Smithy) ContributorsView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	refName, revision, err := sc.MainBranch(repo)
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "contributors")
+	defer cancel()
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	contributors, err := sc.authors.Get(ctx, repo, *revision)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.Render(w, r, "contributors", H{
+		"RepoName":     repoName,
+		"Repo":         repo,
+		"RefName":      refName,
+		"Contributors": contributors,
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GIT_DAEMON_TIMEOUT bounds how long a git:// client may stay idle.
+var GIT_DAEMON_TIMEOUT = 60 * time.Second
+
+// ServeGitDaemon serves read-only access to the repositories over the
+// git:// protocol, like `git daemon` would.
+func (sc *Smithy) ServeGitDaemon(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("git daemon listening on %s", addr)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go sc.handleGitDaemonConn(conn)
+	}
+}
+
+func readPktLine(r io.Reader) (string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid pkt-line length %q", size)
+	}
+	if n < 4 {
+		return "", nil
+	}
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+func writePktError(w io.Writer, msg string) {
+	line := "ERR " + msg + "\n"
+	fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+}
+
+func (sc *Smithy) handleGitDaemonConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(GIT_DAEMON_TIMEOUT))
+
+	br := bufio.NewReader(conn)
+	request, err := readPktLine(br)
+	if err != nil {
+		log.Printf("git daemon: %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	// "git-upload-pack /repo\x00host=example.org\x00"
+	command, rest, _ := strings.Cut(request, " ")
+	repoPath, _, _ := strings.Cut(rest, "\x00")
+	if command != "git-upload-pack" {
+		writePktError(conn, "service not enabled: "+command)
+		return
+	}
+	repoName := strings.Trim(repoPath, "/")
+	if target, ok := sc.ResolveAlias(repoName); ok {
+		repoName = target
+	}
+	repo, exists := sc.FindRepo(repoName)
+	if !exists || !sc.CanRead(nil, repo) {
+		writePktError(conn, "repository not found: "+repoName)
+		return
+	}
+	log.Printf("git daemon: upload-pack %s for %s", repo.Name, conn.RemoteAddr())
+
+	conn.SetDeadline(time.Time{})
+	// No --strict: it refuses working copies, whose git directory is
+	// found in .git. The path is one smithy resolved itself.
+	cmd := exec.Command("git", "upload-pack",
+		fmt.Sprintf("--timeout=%d", int(GIT_DAEMON_TIMEOUT.Seconds())), repo.Path)
+	cmd.Stdin = br
+	// The same bandwidth caps as for HTTP clones apply.
+	cmd.Stdout = NewThrottledWriter(conn, NewRateLimiter(sc.Config().UploadPackRate*1024), sc.uploadPackLimiter)
+	if err := cmd.Run(); err != nil {
+		log.Printf("git daemon: upload-pack %s: %v", repo.Name, err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadPktLine(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"0032git-upload-pack /project.git\x00host=example.org\x00", "git-upload-pack /project.git\x00host=example.org\x00", false},
+		{"0008abcd", "abcd", false},
+		{"0004", "", false},
+		{"0000", "", false},
+		{"0001", "", false},
+		{"000aabc", "", true},
+		{"zzzzabcd", "", true},
+		{"00", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := readPktLine(strings.NewReader(tt.input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readPktLine(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("readPktLine(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestWritePktError(t *testing.T) {
+	var buf bytes.Buffer
+	writePktError(&buf, "access denied")
+	line, err := readPktLine(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "ERR access denied\n" {
+		t.Errorf("round trip = %q", line)
+	}
+}
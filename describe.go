@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/groupcache/lru"
+)
+
+var (
+	// DESCRIBE_WALK_LIMIT is how many commits are visited looking for a tag
+	// before a commit is considered undescribable.
+	DESCRIBE_WALK_LIMIT = 20000
+	// DESCRIBE_CACHE_SIZE is how many commits' describe strings are
+	// remembered.
+	DESCRIBE_CACHE_SIZE = 1024
+)
+
+type describeKey struct {
+	repo string
+	hash plumbing.Hash
+}
+
+type describeEntry struct {
+	refsKey string
+	result  string
+}
+
+// DescribeCache remembers the describe string of the most recently viewed
+// commits, as long as the refs of their repository stay put.
+type DescribeCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func NewDescribeCache() *DescribeCache {
+	return &DescribeCache{cache: lru.New(DESCRIBE_CACHE_SIZE)}
+}
+
+// taggedCommits maps every tagged commit to the name of one of its tags.
+func taggedCommits(r *git.Repository) (map[plumbing.Hash]string, error) {
+	tags, err := ListTags(r)
+	if err != nil {
+		return nil, err
+	}
+	tagged := make(map[plumbing.Hash]string)
+	for _, ref := range tags {
+		info, err := GetTagInfo(r, ref)
+		if err != nil || info.Commit == nil {
+			continue
+		}
+		if _, ok := tagged[info.Commit.Hash]; !ok {
+			tagged[info.Commit.Hash] = info.Name
+		}
+	}
+	return tagged, nil
+}
+
+// Describe names the commit after the most recent tag reachable from it,
+// like git describe --tags: the tag itself when it points at the commit,
+// otherwise the tag, the number of commits since it and the abbreviated
+// hash, e.g. v1.2-3-g1a2b3c4. Commits are counted in committer time order,
+// so the count may differ from git's across merges. It returns "" when no
+// tag is found.
+func Describe(ctx context.Context, r *git.Repository, commit *object.Commit) (string, error) {
+	tagged, err := taggedCommits(r)
+	if err != nil || len(tagged) == 0 {
+		return "", err
+	}
+	iter := object.NewCommitIterCTime(commit, nil, nil)
+	defer iter.Close()
+	for depth := 0; depth < DESCRIBE_WALK_LIMIT; depth++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		c, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		name, ok := tagged[c.Hash]
+		if !ok {
+			continue
+		}
+		if depth == 0 {
+			return name, nil
+		}
+		return fmt.Sprintf("%s-%d-g%s", name, depth, commit.Hash.String()[:7]), nil
+	}
+	return "", nil
+}
+
+// Describe returns the describe string of the commit, see Describe.
+func (dc *DescribeCache) Describe(ctx context.Context, repo RepositoryWithName, commit *object.Commit) (string, error) {
+	key, err := refsKey(repo.Repository)
+	if err != nil {
+		return "", err
+	}
+
+	ck := describeKey{repo: repo.Name, hash: commit.Hash}
+	dc.mu.Lock()
+	cached, ok := dc.cache.Get(ck)
+	dc.mu.Unlock()
+	if ok && cached.(describeEntry).refsKey == key {
+		return cached.(describeEntry).result, nil
+	}
+
+	s, err := Describe(ctx, repo.Repository, commit)
+	if err != nil {
+		return "", err
+	}
+
+	dc.mu.Lock()
+	dc.cache.Add(ck, describeEntry{refsKey: key, result: s})
+	dc.mu.Unlock()
+	return s, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	// DIGEST_MAX_COMMITS limits the commits listed per repository in a
+	// digest.
+	DIGEST_MAX_COMMITS = 50
+	// DIGEST_CHECK_INTERVAL is how often the digest job wakes up to see
+	// whether a digest is due.
+	DIGEST_CHECK_INTERVAL = time.Minute
+)
+
+type MailConfig struct {
+	// SMTPAddr is the host:port of the mail server.
+	SMTPAddr     string
+	SMTPUser     string
+	SMTPPassword string
+	From         string
+}
+
+func (c MailConfig) Enabled() bool {
+	return c.SMTPAddr != "" && c.From != ""
+}
+
+// SendMail sends a plain text message.
+func (c MailConfig) SendMail(to []string, subject, body string) error {
+	var auth smtp.Auth
+	if c.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(c.SMTPAddr)
+		auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPassword, host)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(c.SMTPAddr, auth, c.From, to, []byte(msg.String()))
+}
+
+// DigestMap is a flag.Value accepting "repo=address", repeatable, listing
+// who receives digests of which repositories.
+type DigestMap map[string][]string
+
+func (m DigestMap) String() string {
+	var pairs []string
+	for repo, addresses := range m {
+		for _, address := range addresses {
+			pairs = append(pairs, repo+"="+address)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m DigestMap) Set(value string) error {
+	repo, address, found := strings.Cut(value, "=")
+	if !found || !strings.Contains(address, "@") {
+		return fmt.Errorf("expected repo=address, got %q", value)
+	}
+	m[repo] = append(m[repo], address)
+	return nil
+}
+
+// ParseDigestPeriod accepts "daily", "weekly" or a duration.
+func ParseDigestPeriod(s string) (time.Duration, error) {
+	switch s {
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("digest period must be positive")
+	}
+	return d, err
+}
+
+// DigestCommits returns the commits of all branches of the repository
+// committed after since, newest first.
+func DigestCommits(r *git.Repository, since time.Time) ([]Commit, error) {
+	branches, err := ListBranches(r)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[plumbing.Hash]bool)
+	abbrev := AbbrevLength(r)
+	var commits []Commit
+	for _, branch := range branches {
+		cIter, err := r.Log(&git.LogOptions{From: branch.Hash(), Since: &since})
+		if err != nil {
+			return nil, err
+		}
+		for {
+			commit, err := cIter.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cIter.Close()
+				return nil, err
+			}
+			if !seen[commit.Hash] {
+				seen[commit.Hash] = true
+				commits = append(commits, NewCommit(commit, abbrev))
+			}
+		}
+		cIter.Close()
+	}
+	sort.Slice(commits, func(i, j int) bool {
+		return commits[i].Commit.Committer.When.After(commits[j].Commit.Committer.When)
+	})
+	return commits, nil
+}
+
+// FormatDigest summarizes the activity of repos since the given time.
+func (sc *Smithy) FormatDigest(repos []string, since, until time.Time) (string, bool) {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Activity from %s to %s\n", since.Format("2006-01-02 15:04"), until.Format("2006-01-02 15:04 MST"))
+	active := false
+	for _, name := range repos {
+		repo, exists := sc.FindRepo(name)
+		if !exists {
+			continue
+		}
+		commits, err := DigestCommits(repo.Repository, since)
+		if err != nil {
+			log.Printf("digest of %s: %v", name, err)
+			continue
+		}
+		if len(commits) == 0 {
+			continue
+		}
+		active = true
+		fmt.Fprintf(&body, "\n%s: %d new commits\n", name, len(commits))
+		for i, c := range commits {
+			if i == DIGEST_MAX_COMMITS {
+				fmt.Fprintf(&body, "  ... and %d more\n", len(commits)-i)
+				break
+			}
+			fmt.Fprintf(&body, "  %s %s (%s)\n", c.ShortHash, c.Subject, c.Commit.Author.Name)
+		}
+		fmt.Fprintf(&body, "  %s/%s/log\n", sc.Config().CloneURL(), name)
+	}
+	return body.String(), active
+}
+
+// SendDigests mails every recipient one digest of their repositories,
+// skipping those without activity.
+func (sc *Smithy) SendDigests(since, until time.Time) {
+	byAddress := make(map[string][]string)
+	for repo, addresses := range sc.Config().Digests {
+		for _, address := range addresses {
+			byAddress[address] = append(byAddress[address], repo)
+		}
+	}
+	for address, repos := range byAddress {
+		sort.Strings(repos)
+		body, active := sc.FormatDigest(repos, since, until)
+		if !active {
+			continue
+		}
+		subject := fmt.Sprintf("[%s] Activity digest for %s", sc.Config().Title, until.Format("2006-01-02"))
+		if err := sc.Config().Mail.SendMail([]string{address}, subject, body); err != nil {
+			log.Printf("digest to %s: %v", address, err)
+		}
+	}
+}
+
+type digestState struct {
+	Last time.Time `json:"last"`
+}
+
+// SendDigestsPeriodically sends digests every DigestPeriod, forever. When
+// the last one was sent is saved, so restarts neither skip nor repeat
+// activity.
+func (sc *Smithy) SendDigestsPeriodically() {
+	statePath := sc.Config().DataPath("digest.json")
+	var state digestState
+	if data, err := os.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	if state.Last.IsZero() {
+		state.Last = time.Now()
+	}
+	for {
+		if !sc.Config().Mail.Enabled() || len(sc.Config().Digests) == 0 || sc.Config().DigestPeriod <= 0 {
+			time.Sleep(DIGEST_CHECK_INTERVAL)
+			continue
+		}
+		if next := state.Last.Add(sc.Config().DigestPeriod); time.Now().Before(next) {
+			time.Sleep(DIGEST_CHECK_INTERVAL)
+			continue
+		}
+		now := time.Now()
+		sc.SendDigests(state.Last, now)
+		state.Last = now
+		data, _ := json.Marshal(state)
+		err := os.MkdirAll(filepath.Dir(statePath), 0755)
+		if err == nil {
+			err = os.WriteFile(statePath, data, 0644)
+		}
+		if err != nil {
+			log.Printf("saving digest state: %v", err)
+		}
+	}
+}
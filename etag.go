@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ETag returns a strong entity tag for a page rendered from the objects
+// named by parts, such as a commit hash. The viewer, the URL, the locale,
+// display preferences and reloads all vary the rendering, so they are
+// mixed in too.
+func (sc *Smithy) ETag(r *http.Request, parts ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%d\x00%s\x00%s\x00%s\x00%s\x00", startTime.UnixNano(), sc.generation.Load(),
+		sc.CurrentUser(r), r.URL.Path, r.URL.RawQuery, sc.Locale(r).Name)
+	if c, err := r.Cookie(PREFS_COOKIE); err == nil {
+		fmt.Fprintf(h, "%s\x00", c.Value)
+	}
+	for _, part := range parts {
+		fmt.Fprintf(h, "%s\x00", part)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// NotModified sets the ETag of the response and answers 304 Not Modified
+// when the client already has that version. Immutable responses, those
+// addressed by a full object hash, may also be cached without revalidation.
+func (sc *Smithy) NotModified(w http.ResponseWriter, r *http.Request, immutable bool, parts ...string) bool {
+	etag := sc.ETag(r, parts...)
+	w.Header().Set("ETag", etag)
+	if immutable {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// FEED_SIZE is the number of entries in generated feeds.
+var FEED_SIZE = 50
+
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type AtomAuthor struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type AtomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  AtomAuthor  `xml:"author"`
+	Link    AtomLink    `xml:"link"`
+	Content AtomContent `xml:"content"`
+}
+
+// BaseURL returns the external URL when configured, or else the scheme and
+// host the request was made to.
+func (sc *Smithy) BaseURL(r *http.Request) string {
+	if sc.Config().ExternalURL != "" {
+		return strings.TrimSuffix(sc.Config().ExternalURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// CommitFeed builds a feed with one entry per commit.
+func CommitFeed(baseURL, repoName, title, selfURL string, commits []Commit) AtomFeed {
+	feed := AtomFeed{
+		Title: title,
+		ID:    selfURL,
+		Links: []AtomLink{
+			{Href: selfURL, Rel: "self"},
+			{Href: fmt.Sprintf("%s/%s", baseURL, repoName), Rel: "alternate"},
+		},
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+	}
+	if len(commits) > 0 {
+		feed.Updated = commits[0].Commit.Committer.When.UTC().Format(time.RFC3339)
+	}
+	for _, c := range commits {
+		link := fmt.Sprintf("%s/%s/commit/%s", baseURL, repoName, c.Commit.Hash)
+		feed.Entries = append(feed.Entries, AtomEntry{
+			Title:   c.Subject,
+			ID:      link,
+			Updated: c.Commit.Committer.When.UTC().Format(time.RFC3339),
+			Author: AtomAuthor{
+				Name:  c.Commit.Author.Name,
+				Email: c.Commit.Author.Email,
+			},
+			Link:    AtomLink{Href: link, Rel: "alternate"},
+			Content: AtomContent{Type: "text", Body: c.Commit.Message},
+		})
+	}
+	return feed
+}
+
+// FeedETag identifies a feed by its newest entry, so it changes whenever a
+// commit or tag is added.
+func FeedETag(feed AtomFeed) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n", feed.ID, feed.Updated, len(feed.Entries))
+	if len(feed.Entries) > 0 {
+		fmt.Fprintf(h, "%s\n", feed.Entries[0].ID)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// WriteFeed serves a feed with ETag and Last-Modified validators and
+// answers conditional requests from feed readers with 304 Not Modified.
+func (sc *Smithy) WriteFeed(w http.ResponseWriter, r *http.Request, feed AtomFeed) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	updated, _ := time.Parse(time.RFC3339, feed.Updated)
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("ETag", FeedETag(feed))
+	http.ServeContent(w, r, "", updated, bytes.NewReader(buf.Bytes()))
+}
+
+// LogFeedView serves an Atom feed of the commits of a ref, optionally only
+// those touching a path.
+func (sc *Smithy) LogFeedView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	refName := sc.GetParam(r, "ref")
+	treePath := sc.GetParam(r, "path")
+	revision, err := repo.Repository.ResolveRevision(plumbing.Revision(refName))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "log")
+	defer cancel()
+
+	commits, err := CollectCommits(ctx, repo.Repository, *revision, treePath, 0, FEED_SIZE)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	baseURL := sc.BaseURL(r)
+	title := fmt.Sprintf("%s: %s", repoName, refName)
+	if treePath != "" {
+		title = fmt.Sprintf("%s: %s on %s", repoName, treePath, refName)
+	}
+	sc.WriteFeed(w, r, CommitFeed(baseURL, repoName, title, baseURL+r.URL.Path, commits))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/groupcache/lru"
+)
+
+var (
+	// FILE_LIST_CACHE_SIZE is how many commits' file lists are remembered.
+	FILE_LIST_CACHE_SIZE = 64
+	// FIND_MAX_RESULTS is how many files the file finder shows.
+	FIND_MAX_RESULTS = 100
+)
+
+// FileListCache remembers the paths of the files of commits. Commits are
+// immutable, so entries never go stale.
+type FileListCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func NewFileListCache() *FileListCache {
+	return &FileListCache{cache: lru.New(FILE_LIST_CACHE_SIZE)}
+}
+
+// Get returns the sorted paths of all files in the tree of commit.
+func (fc *FileListCache) Get(ctx context.Context, commit *object.Commit) ([]string, error) {
+	fc.mu.Lock()
+	cached, ok := fc.cache.Get(commit.Hash)
+	fc.mu.Unlock()
+	if ok {
+		return cached.([]string), nil
+	}
+	files, err := ListFiles(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	fc.mu.Lock()
+	fc.cache.Add(commit.Hash, files)
+	fc.mu.Unlock()
+	return files, nil
+}
+
+// ListFiles returns the sorted paths of all files in the tree of commit.
+func ListFiles(ctx context.Context, commit *object.Commit) ([]string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode.IsFile() {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// FuzzyScore matches query against name as a subsequence, ignoring case.
+// Matches at the start of path segments or words, runs of consecutive
+// characters and matches in the file name score higher. It returns false
+// when name does not contain the characters of query in order.
+func FuzzyScore(query, name string) (int, bool) {
+	query = strings.ToLower(query)
+	lower := strings.ToLower(name)
+	if len(lower) != len(name) {
+		// Lowercasing changed the length, fall back to the original.
+		lower = name
+	}
+	base := strings.LastIndex(name, "/") + 1
+	score := 0
+	prev := -2
+	i := 0
+	for _, q := range query {
+		j := strings.IndexRune(lower[i:], q)
+		if j < 0 {
+			return 0, false
+		}
+		j += i
+		score++
+		if j == prev+1 {
+			score += 4
+		}
+		if j == 0 || strings.ContainsRune("/-_. ", rune(name[j-1])) {
+			score += 3
+		} else if r, _ := utf8.DecodeRuneInString(name[j:]); unicode.IsUpper(r) {
+			score += 2
+		}
+		if j >= base {
+			score += 2
+		}
+		prev = j
+		i = j + utf8.RuneLen(q)
+	}
+	return score, true
+}
+
+// FindFiles returns at most max of files fuzzily matching query, best
+// matches first and shorter paths before longer ones.
+func FindFiles(files []string, query string, max int) []string {
+	type match struct {
+		path  string
+		score int
+	}
+	var matches []match
+	for _, f := range files {
+		if score, ok := FuzzyScore(query, f); ok {
+			matches = append(matches, match{f, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return len(matches[i].path) < len(matches[j].path)
+	})
+	found := []string{}
+	for i := 0; i < len(matches) && i < max; i++ {
+		found = append(found, matches[i].path)
+	}
+	return found
+}
+
+// FindView lists the files of a ref fuzzily matching ?q=, to jump to a file
+// by typing a few letters of its path.
+func (sc *Smithy) FindView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	refName := sc.GetParam(r, "ref")
+	revision, err := repo.Repository.ResolveRevision(plumbing.Revision(refName))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	commit, err := repo.Repository.CommitObject(*revision)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "find")
+	defer cancel()
+	files, err := sc.files.Get(ctx, commit)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	var found []string
+	if query != "" {
+		found = FindFiles(files, query, FIND_MAX_RESULTS)
+	}
+	sc.Render(w, r, "find", H{
+		"RepoName": repoName,
+		"RefName":  refName,
+		"Query":    query,
+		"Files":    found,
+		"Total":    len(files),
+	})
+}
+
+// APIFiles returns the paths of all files of a ref, or those fuzzily
+// matching ?q=, best matches first.
+func (sc *Smithy) APIFiles(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	commit, err := ResolveCommit(repo.Repository, sc.GetParam(r, "ref"))
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	ctx, cancel := sc.OperationContext(r, "find")
+	defer cancel()
+	files, err := sc.files.Get(ctx, commit)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if query := strings.TrimSpace(r.URL.Query().Get("q")); query != "" {
+		files = FindFiles(files, query, FIND_MAX_RESULTS)
+	}
+	sc.JSON(w, http.StatusOK, files)
+}
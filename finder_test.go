@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		query, name string
+		ok          bool
+	}{
+		{"", "main.go", true},
+		{"main", "main.go", true},
+		{"mgo", "main.go", true},
+		{"MAIN", "main.go", true},
+		{"fs", "finder/search.go", true},
+		{"züri", "Zürich.md", true},
+		{"gom", "main.go", false},
+		{"mainx", "main.go", false},
+		{"x", "", false},
+	}
+	for _, tt := range tests {
+		if _, ok := FuzzyScore(tt.query, tt.name); ok != tt.ok {
+			t.Errorf("FuzzyScore(%q, %q) ok = %v, want %v", tt.query, tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestFuzzyScoreRanking(t *testing.T) {
+	tests := []struct {
+		query, better, worse string
+	}{
+		// Consecutive characters beat scattered ones.
+		{"read", "README.md", "r/e/a/d.go"},
+		// Matches in the file name beat matches in directories.
+		{"util", "src/util.go", "util/src.go"},
+		// Matches at word starts beat matches inside words.
+		{"fs", "file_search.go", "offset.go"},
+	}
+	for _, tt := range tests {
+		better, _ := FuzzyScore(tt.query, tt.better)
+		worse, _ := FuzzyScore(tt.query, tt.worse)
+		if better <= worse {
+			t.Errorf("FuzzyScore(%q): %q scored %d, not above %q with %d", tt.query, tt.better, better, tt.worse, worse)
+		}
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	files := []string{"docs/main.md", "main.go", "cmd/tool/main.go", "README.md"}
+	got := FindFiles(files, "main", 2)
+	want := []string{"main.go", "docs/main.md"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindFiles = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// GoImport answers `go get` with the go-import meta tag of the repository
+// the import path starts with, so packages can live at the clone URL.
+func (sc *Smithy) GoImport(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("go-get") != "1" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, _ := splitRepoPath(strings.TrimPrefix(r.URL.Path, "/"), sc.RepoExists)
+		repo, exists := sc.FindRepo(name)
+		if !exists || !sc.CanRead(r, repo) {
+			http.NotFound(w, r)
+			return
+		}
+		cloneURL := sc.Config().CloneURL() + "/" + repo.Name
+		prefix := cloneURL[strings.Index(cloneURL, "://")+3:]
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<!doctype html>\n<html><head><meta name=\"go-import\" content=\"%s git %s\"></head></html>\n",
+			template.HTMLEscapeString(prefix), template.HTMLEscapeString(cloneURL))
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/golang/groupcache/lru"
+)
+
+// RepoHandles keeps recently used repositories open, up to a limit.
+// Evicted handles are only dropped, not closed, since a request may still be
+// using them; the storage keeps no file descriptors open between reads.
+type RepoHandles struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+// NewRepoHandles returns a cache of at most max open repositories, or of any
+// number if max is not positive.
+func NewRepoHandles(max int) *RepoHandles {
+	if max < 0 {
+		max = 0
+	}
+	return &RepoHandles{cache: lru.New(max)}
+}
+
+func (h *RepoHandles) Get(name string) (RepositoryWithName, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v, ok := h.cache.Get(name); ok {
+		return v.(RepositoryWithName), true
+	}
+	return RepositoryWithName{}, false
+}
+
+func (h *RepoHandles) Add(rwn RepositoryWithName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cache.Add(rwn.Name, rwn)
+}
+
+func (h *RepoHandles) Remove(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cache.Remove(name)
+}
+
+// Clear forgets all handles. Like evicted ones they are not closed.
+func (h *RepoHandles) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cache.Clear()
+}
+
+// isRepository reports whether dir looks like a bare or working-copy git
+// repository, without opening it.
+func isRepository(dir string) bool {
+	if _, err := os.Stat(path.Join(dir, ".git")); err == nil {
+		return true
+	}
+	_, err := os.Stat(path.Join(dir, "HEAD"))
+	return err == nil
+}
+
+// peekRepo returns the known repository with a handle for a brief look,
+// such as reading its refs in a listing. A cached handle is reused, but a
+// new one is not cached, so listings do not push out busy repositories.
+func (sc *Smithy) peekRepo(known RepositoryWithName) (RepositoryWithName, error) {
+	if rwn, ok := sc.handles.Get(known.Name); ok {
+		return rwn, nil
+	}
+	s, err := settingsStorer(known.Path)
+	if err != nil {
+		return RepositoryWithName{}, err
+	}
+	r, err := git.Open(s, nil)
+	if err != nil {
+		return RepositoryWithName{}, err
+	}
+	rwn := known
+	rwn.Repository = r
+	return rwn, nil
+}
+
+// openRepo returns the known repository with an open handle.
+func (sc *Smithy) openRepo(known RepositoryWithName) (RepositoryWithName, error) {
+	if rwn, ok := sc.handles.Get(known.Name); ok {
+		return rwn, nil
+	}
+	r, err := sc.OpenRepository(known.Path)
+	if err != nil {
+		return RepositoryWithName{}, err
+	}
+	rwn := known
+	rwn.Repository = r
+	rwn.Bare = IsBare(r)
+	sc.handles.Add(rwn)
+	return rwn, nil
+}
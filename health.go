@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+var startTime = time.Now()
+
+// Healthz reports that the process is up and serving requests.
+func (sc *Smithy) Healthz(w http.ResponseWriter, r *http.Request) {
+	sc.JSON(w, http.StatusOK, H{
+		"status": "ok",
+		"uptime": time.Since(startTime).Round(time.Second).String(),
+	})
+}
+
+// Readyz reports whether the templates are parsed and the repositories root
+// has been scanned, answering 503 until both are done.
+func (sc *Smithy) Readyz(w http.ResponseWriter, r *http.Request) {
+	sc.reposMu.RLock()
+	checks := H{
+		"templates":    sc.template != nil,
+		"repositories": sc.repos != nil,
+	}
+	sc.reposMu.RUnlock()
+	status, code := "ok", http.StatusOK
+	for _, ok := range checks {
+		if !ok.(bool) {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	sc.JSON(w, code, H{
+		"status":       status,
+		"checks":       checks,
+		"repositories": sc.RepoCount(),
+	})
+}
+
+// isProbeRequest reports whether the request is for one of the health
+// endpoints, which stay reachable without credentials so that container
+// runtimes can poll them.
+func isProbeRequest(r *http.Request) bool {
+	return r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// HIGHLIGHT_STYLE is the chroma style used for code and READMEs.
+var HIGHLIGHT_STYLE = "github"
+
+type HighlightedBlob struct {
+	HTML template.HTML
+	// Plain is set when the file was rendered without highlighting.
+	Plain bool
+	// Reason tells why highlighting was skipped or the file cut short.
+	Reason string
+	// Truncated is set when only the start of the file was rendered.
+	Truncated bool
+}
+
+type HighlightOptions struct {
+	// MaxLines disables highlighting for longer files.
+	MaxLines int
+	// MaxBytes renders only the lines within the first MaxBytes bytes of
+	// larger files.
+	MaxBytes       int64
+	Wrap           bool
+	ShowWhitespace bool
+}
+
+var whitespaceMarkers = strings.NewReplacer(" ", "·", "\t", "→\t")
+
+func newCodeFormatter(wrap bool) *html.Formatter {
+	return html.New(
+		html.WithClasses(true),
+		html.WithLineNumbers(true),
+		html.LineNumbersInTable(true),
+		html.LinkableLineNumbers(true, "L"),
+		html.WrapLongLines(wrap),
+	)
+}
+
+// RenderSyntaxHighlighting renders file contents as highlighted HTML with
+// line numbers. Files with more than MaxLines lines are rendered as plain
+// text, since chroma's table mode gets expensive on large inputs.
+func RenderSyntaxHighlighting(name, contents string, opts HighlightOptions) (HighlightedBlob, error) {
+	lines := strings.Count(contents, "\n")
+	if opts.MaxLines > 0 && lines > opts.MaxLines {
+		if opts.ShowWhitespace {
+			contents = whitespaceMarkers.Replace(contents)
+		}
+		pre := "<pre>"
+		if opts.Wrap {
+			pre = `<pre style="white-space: pre-wrap; word-break: break-all">`
+		}
+		return HighlightedBlob{
+			HTML:   template.HTML(pre + template.HTMLEscapeString(contents) + "</pre>"),
+			Plain:  true,
+			Reason: fmt.Sprintf("Highlighting disabled for large file (%d lines, limit %d).", lines, opts.MaxLines),
+		}, nil
+	}
+
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		lexer = lexers.Analyse(contents)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, contents)
+	if err != nil {
+		return HighlightedBlob{}, err
+	}
+	if opts.ShowWhitespace {
+		tokens := iterator.Tokens()
+		for i := range tokens {
+			tokens[i].Value = whitespaceMarkers.Replace(tokens[i].Value)
+		}
+		iterator = chroma.Literator(tokens...)
+	}
+	var buf bytes.Buffer
+	if err := newCodeFormatter(opts.Wrap).Format(&buf, styles.Get(HIGHLIGHT_STYLE), iterator); err != nil {
+		return HighlightedBlob{}, err
+	}
+	return HighlightedBlob{HTML: template.HTML(buf.String())}, nil
+}
+
+// HighlightCSS serves the stylesheet for the highlighting classes.
+func (sc *Smithy) HighlightCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	newCodeFormatter(false).WriteCSS(w, styles.Get(HIGHLIGHT_STYLE))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// ValidRepoName reports whether name can be used for a new repository in the
+// root directory, optionally inside namespaces ("team/project").
+func ValidRepoName(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || part != filepath.Base(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRepository reports whether a namespace of name is a repository, which
+// would make name a directory inside it.
+func (sc *Smithy) inRepository(name string) bool {
+	for ns := RepoNamespace(name); ns != ""; ns = RepoNamespace(ns) {
+		if isRepository(filepath.Join(sc.Root, ns)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportRepository clones url bare into the root as name. Mirrors keep their
+// origin remote so they can be fetched again later; one-time imports drop it.
+func (sc *Smithy) ImportRepository(ctx context.Context, name, url string, mirror bool) (RepositoryWithName, error) {
+	if !ValidRepoName(name) || sc.inRepository(name) {
+		return RepositoryWithName{}, fmt.Errorf("Invalid repository name %q", name)
+	}
+	if url == "" {
+		return RepositoryWithName{}, fmt.Errorf("Missing repository URL")
+	}
+	repoPath := filepath.Join(sc.Root, name)
+	if _, err := os.Stat(repoPath); err == nil {
+		return RepositoryWithName{}, fmt.Errorf("Repository %s already exists", name)
+	}
+
+	_, err := git.PlainCloneContext(ctx, repoPath, true, &git.CloneOptions{
+		URL:    url,
+		Mirror: mirror,
+	})
+	if err != nil {
+		os.RemoveAll(repoPath)
+		return RepositoryWithName{}, contextError(ctx, err)
+	}
+	r, err := sc.OpenRepository(repoPath)
+	if err != nil {
+		os.RemoveAll(repoPath)
+		return RepositoryWithName{}, err
+	}
+	if !mirror {
+		if err := r.DeleteRemote("origin"); err != nil {
+			os.RemoveAll(repoPath)
+			return RepositoryWithName{}, err
+		}
+	}
+	rwn := RepositoryWithName{
+		Name:       name,
+		Repository: r,
+		Path:       repoPath,
+		Bare:       true,
+	}
+	sc.AddRepository(rwn)
+	return rwn, nil
+}
+
+// CreateRepository initializes an empty bare repository.
+func (sc *Smithy) CreateRepository(name string) (RepositoryWithName, error) {
+	if !ValidRepoName(name) || sc.inRepository(name) {
+		return RepositoryWithName{}, fmt.Errorf("Invalid repository name %q", name)
+	}
+	repoPath := filepath.Join(sc.Root, name)
+	if _, err := os.Stat(repoPath); err == nil {
+		return RepositoryWithName{}, fmt.Errorf("Repository %s already exists", name)
+	}
+	if _, err := git.PlainInit(repoPath, true); err != nil {
+		return RepositoryWithName{}, err
+	}
+	r, err := sc.OpenRepository(repoPath)
+	if err != nil {
+		return RepositoryWithName{}, err
+	}
+	rwn := RepositoryWithName{
+		Name:       name,
+		Repository: r,
+		Path:       repoPath,
+		Bare:       true,
+	}
+	sc.AddRepository(rwn)
+	return rwn, nil
+}
+
+func (sc *Smithy) ImportProject(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		sc.Render(w, r, "import", H{})
+		return
+	}
+	r.ParseForm()
+	ctx, cancel := sc.OperationContext(r, "import")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	repo, err := sc.ImportRepository(ctx, r.FormValue("name"), r.FormValue("git"), r.FormValue("mirror") == "on")
+	if err != nil {
+		sc.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	http.Redirect(w, r, "/"+repo.Name, http.StatusSeeOther)
+}
+
+type APIImportRequest struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Mirror bool   `json:"mirror"`
+}
+
+// APIImportRepo clones a remote repository, see ImportRepository.
+func (sc *Smithy) APIImportRepo(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	var req APIImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sc.JSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := sc.OperationContext(r, "import")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	repo, err := sc.ImportRepository(ctx, req.Name, req.URL, req.Mirror)
+	if err != nil {
+		sc.JSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	sc.JSON(w, http.StatusCreated, sc.NewAPIRepo(repo))
+}
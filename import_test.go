@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidRepoName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"project", true},
+		{"project.git", true},
+		{"team/project", true},
+		{"team/tools/project", true},
+		{"", false},
+		{".smithy", false},
+		{"team/.hidden", false},
+		{"..", false},
+		{"../escape", false},
+		{"team/../escape", false},
+		{"/absolute", false},
+		{"trailing/", false},
+		{"double//slash", false},
+	}
+	for _, tt := range tests {
+		if got := ValidRepoName(tt.name); got != tt.want {
+			t.Errorf("ValidRepoName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInRepository(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"bare.git", "team/work/.git", "team/plain"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "bare.git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc := NewSmithy(SmithyConfig{Root: root})
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"new", false},
+		{"bare.git", false},
+		{"bare.git/inside", true},
+		{"bare.git/deep/inside", true},
+		{"team/new", false},
+		{"team/work/inside", true},
+		{"team/plain/new", false},
+	}
+	for _, tt := range tests {
+		if got := sc.inRepository(tt.name); got != tt.want {
+			t.Errorf("inRepository(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
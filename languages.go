@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// LANGUAGE_IGNORED lists languages that are prose or data rather
+	// than code, and are left out of language statistics.
+	LANGUAGE_IGNORED = []string{"plaintext", "markdown", "reStructuredText", "JSON", "YAML", "TOML", "Diff"}
+	// LANGUAGE_VENDORED lists directories whose files are left out of
+	// language statistics, wherever they are in the tree.
+	LANGUAGE_VENDORED = []string{"vendor", "node_modules", "third_party"}
+	// LANGUAGE_IGNORED_FILES lists manifests and lock files whose names
+	// would be mistaken for code.
+	LANGUAGE_IGNORED_FILES = []string{"go.mod", "go.sum", "Cargo.lock", "package-lock.json", "yarn.lock"}
+)
+
+// LanguageShare is how many bytes of a tree are written in a language.
+type LanguageShare struct {
+	Name    string  `json:"name"`
+	Bytes   int64   `json:"bytes"`
+	Percent float64 `json:"percent"`
+}
+
+// Color derives a stable color for the language bar from the name.
+func (l LanguageShare) Color() string {
+	h := fnv.New32a()
+	h.Write([]byte(l.Name))
+	sum := h.Sum32()
+	return fmt.Sprintf("#%02x%02x%02x", 0x40+sum&0x9f, 0x40+(sum>>8)&0x9f, 0x40+(sum>>16)&0x9f)
+}
+
+func isVendored(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if containsString(LANGUAGE_VENDORED, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputeLanguages sums the sizes of the files of commit's tree by the
+// language their name suggests, largest share first. Files of unknown,
+// ignored or vendored languages are not counted.
+func ComputeLanguages(ctx context.Context, commit *object.Commit) ([]LanguageShare, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	var total int64
+	files := tree.Files()
+	defer files.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		file, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isVendored(file.Name) || containsString(LANGUAGE_IGNORED_FILES, path.Base(file.Name)) {
+			continue
+		}
+		lexer := lexers.Match(file.Name)
+		if lexer == nil {
+			continue
+		}
+		name := lexer.Config().Name
+		if containsString(LANGUAGE_IGNORED, name) {
+			continue
+		}
+		sizes[name] += file.Size
+		total += file.Size
+	}
+	languages := []LanguageShare{}
+	for name, size := range sizes {
+		languages = append(languages, LanguageShare{
+			Name:    name,
+			Bytes:   size,
+			Percent: float64(size) * 100 / float64(total),
+		})
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if languages[i].Bytes != languages[j].Bytes {
+			return languages[i].Bytes > languages[j].Bytes
+		}
+		return languages[i].Name < languages[j].Name
+	})
+	return languages, nil
+}
+
+type languagesEntry struct {
+	head      plumbing.Hash
+	languages []LanguageShare
+}
+
+// LanguageCache keeps the language statistics of the default branch of
+// each repository, recomputed whenever its head moves.
+type LanguageCache struct {
+	mu      sync.Mutex
+	entries map[string]*languagesEntry
+}
+
+func NewLanguageCache() *LanguageCache {
+	return &LanguageCache{entries: make(map[string]*languagesEntry)}
+}
+
+// Get returns the language statistics of a repository as of commit.
+func (lc *LanguageCache) Get(ctx context.Context, repo RepositoryWithName, commit *object.Commit) ([]LanguageShare, error) {
+	lc.mu.Lock()
+	entry, ok := lc.entries[repo.Name]
+	lc.mu.Unlock()
+	if ok && entry.head == commit.Hash {
+		return entry.languages, nil
+	}
+	languages, err := ComputeLanguages(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	lc.mu.Lock()
+	lc.entries[repo.Name] = &languagesEntry{head: commit.Hash, languages: languages}
+	lc.mu.Unlock()
+	return languages, nil
+}
+
+// APILanguages returns the language statistics of the default branch.
+func (sc *Smithy) APILanguages(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	_, revision, err := sc.MainBranch(repo)
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	commit, err := repo.Repository.CommitObject(*revision)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ctx, cancel := sc.OperationContext(r, "languages")
+	defer cancel()
+	languages, err := sc.languages.Get(ctx, repo, commit)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.JSON(w, http.StatusOK, languages)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/groupcache/lru"
+)
+
+var (
+	// LAST_CHANGE_DEPTH is how many first-parent commits are walked looking
+	// for the last change of the subdirectories in a tree view.
+	LAST_CHANGE_DEPTH = 1000
+	// LAST_CHANGE_CACHE_SIZE is how many directory listings' last changes
+	// are remembered.
+	LAST_CHANGE_CACHE_SIZE = 1024
+)
+
+// TreeRow is an entry of a tree view. Subdirectories also carry how many
+// entries they have and the commit that last changed them, when known, and
+// submodules where they point.
+type TreeRow struct {
+	object.TreeEntry
+	Entries    int
+	LastChange *object.Commit
+	Submodule  *SubmoduleLink
+}
+
+// LastChangeCache remembers the last changes found for the entries of a
+// directory at a commit. Both are immutable, so entries never go stale.
+type LastChangeCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func NewLastChangeCache() *LastChangeCache {
+	return &LastChangeCache{cache: lru.New(LAST_CHANGE_CACHE_SIZE)}
+}
+
+// Get returns the commits that last changed each entry of dirPath, as of
+// from. Entries not changed within LAST_CHANGE_DEPTH commits are missing.
+func (lc *LastChangeCache) Get(ctx context.Context, from *object.Commit, dirPath string) (map[string]*object.Commit, error) {
+	key := from.Hash.String() + ":" + dirPath
+	lc.mu.Lock()
+	cached, ok := lc.cache.Get(key)
+	lc.mu.Unlock()
+	if ok {
+		return cached.(map[string]*object.Commit), nil
+	}
+	changes, err := LastChanges(ctx, from, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	lc.mu.Lock()
+	lc.cache.Add(key, changes)
+	lc.mu.Unlock()
+	return changes, nil
+}
+
+// dirEntries maps the entry names of dirPath in commit to their hashes. It
+// also returns the hash of the directory itself.
+func dirEntries(commit *object.Commit, dirPath string) (plumbing.Hash, map[string]plumbing.Hash) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, nil
+	}
+	if dirPath != "" {
+		if tree, err = tree.Tree(dirPath); err != nil {
+			return plumbing.ZeroHash, nil
+		}
+	}
+	entries := make(map[string]plumbing.Hash, len(tree.Entries))
+	for _, e := range tree.Entries {
+		entries[e.Name] = e.Hash
+	}
+	return tree.Hash, entries
+}
+
+// LastChanges walks the first-parent history of from and reports, for each
+// entry of dirPath, the newest commit whose parent had a different version
+// of it.
+func LastChanges(ctx context.Context, from *object.Commit, dirPath string) (map[string]*object.Commit, error) {
+	dirPath = path.Clean("/" + dirPath)[1:]
+	changes := make(map[string]*object.Commit)
+	hash, want := dirEntries(from, dirPath)
+	commit := from
+	for i := 0; i < LAST_CHANGE_DEPTH && len(changes) < len(want); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var parent *object.Commit
+		var parentHash plumbing.Hash
+		var parentEntries map[string]plumbing.Hash
+		if commit.NumParents() > 0 {
+			p, err := commit.Parent(0)
+			if err != nil {
+				return nil, err
+			}
+			parent = p
+			parentHash, parentEntries = dirEntries(parent, dirPath)
+		}
+		if parentHash != hash {
+			for name, h := range want {
+				if _, found := changes[name]; !found && parentEntries[name] != h {
+					changes[name] = commit
+				}
+			}
+		}
+		if parent == nil {
+			break
+		}
+		commit, hash = parent, parentHash
+	}
+	return changes, nil
+}
+
+// TreeRows describes the entries of dirPath in commit for the tree view.
+// Last changes are best effort: they are left out when looking them up
+// fails or takes too long.
+func (sc *Smithy) TreeRows(ctx context.Context, repo RepositoryWithName, commit *object.Commit, dirPath string, entries []object.TreeEntry) []TreeRow {
+	changes, err := sc.lastChanges.Get(ctx, commit, dirPath)
+	if err != nil {
+		log.Printf("last changes of %s/%s: %v", repo.Name, dirPath, err)
+	}
+	rows := make([]TreeRow, len(entries))
+	var modules map[string]string
+	for i, e := range entries {
+		rows[i].TreeEntry = e
+		if e.Mode == filemode.Submodule {
+			if modules == nil {
+				modules = Gitmodules(commit)
+			}
+			rows[i].Submodule = sc.Submodule(repo.Name, modules[path.Join(dirPath, e.Name)], e.Hash)
+			rows[i].LastChange = changes[e.Name]
+			continue
+		}
+		if e.Mode != filemode.Dir {
+			continue
+		}
+		if subTree, err := repo.Repository.TreeObject(e.Hash); err == nil {
+			rows[i].Entries = len(subTree.Entries)
+		}
+		rows[i].LastChange = changes[e.Name]
+	}
+	return rows
+}
@@ -0,0 +1,21 @@
+package main
+
+import "net/http"
+
+// HistoryLimits caps how far into history a request may reach. Zero means
+// unlimited.
+type HistoryLimits struct {
+	// LogDepth is how many commits from the tip log pages may show.
+	LogDepth int
+	// RangeSize is how many commits a patch series may contain.
+	RangeSize int
+}
+
+// HistoryLimits returns the limits for the request: anonymous visitors get
+// the usually tighter AnonymousLimits.
+func (sc *Smithy) HistoryLimits(r *http.Request) HistoryLimits {
+	if sc.CurrentUser(r) == "" {
+		return sc.Config().AnonymousLimits
+	}
+	return sc.Config().UserLimits
+}
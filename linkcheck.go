@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	// LINK_CHECK_TIMEOUT bounds the check of a single link.
+	LINK_CHECK_TIMEOUT = 30 * time.Second
+	// LINK_CHECK_INTERVAL is how often the link checker looks whether a
+	// check is due.
+	LINK_CHECK_INTERVAL = time.Minute
+)
+
+var linkCheckClient = &http.Client{Timeout: LINK_CHECK_TIMEOUT}
+
+// BrokenLink is a website or mirror remote of a repository that could not
+// be reached.
+type BrokenLink struct {
+	Repo  string
+	Kind  string
+	URL   string
+	Error string
+}
+
+// LinkReport holds the broken links found by the last check.
+type LinkReport struct {
+	mu      sync.Mutex
+	checked time.Time
+	broken  []BrokenLink
+	running bool
+}
+
+// Get returns when links were last checked and which were broken.
+func (lr *LinkReport) Get() (time.Time, []BrokenLink, bool) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return lr.checked, lr.broken, lr.running
+}
+
+// checkWebsite reports whether a website answers without an error status.
+// Servers that do not allow HEAD requests are asked with GET.
+func checkWebsite(ctx context.Context, url string) error {
+	var resp *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err = linkCheckClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			break
+		}
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// checkMirror reports whether the origin remote of a mirror lists its refs.
+func checkMirror(ctx context.Context, remote *git.Remote) error {
+	_, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err == nil || err == transport.ErrEmptyRemoteRepository {
+		return nil
+	}
+	return err
+}
+
+// CheckLinks checks the website of every repository and the origin remote
+// of every mirror, and returns those that could not be reached.
+func (sc *Smithy) CheckLinks() []BrokenLink {
+	var broken []BrokenLink
+	for _, repo := range sc.GetRepositories() {
+		if website := sc.RepoSettings(repo).Website; website != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), LINK_CHECK_TIMEOUT)
+			if err := checkWebsite(ctx, website); err != nil {
+				broken = append(broken, BrokenLink{Repo: repo.Name, Kind: "website", URL: website, Error: err.Error()})
+			}
+			cancel()
+		}
+		open, err := sc.openRepo(repo)
+		if err != nil {
+			continue
+		}
+		remote, err := open.Repository.Remote("origin")
+		if err != nil || len(remote.Config().URLs) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), LINK_CHECK_TIMEOUT)
+		if err := checkMirror(ctx, remote); err != nil {
+			broken = append(broken, BrokenLink{Repo: repo.Name, Kind: "mirror", URL: remote.Config().URLs[0], Error: err.Error()})
+		}
+		cancel()
+	}
+	return broken
+}
+
+// RunLinkCheck checks links unless a check is already running, and keeps
+// the result for the admin page.
+func (sc *Smithy) RunLinkCheck() {
+	lr := sc.links
+	lr.mu.Lock()
+	if lr.running {
+		lr.mu.Unlock()
+		return
+	}
+	lr.running = true
+	lr.mu.Unlock()
+
+	broken := sc.CheckLinks()
+	for _, link := range broken {
+		log.Printf("broken %s link of %s: %s: %s", link.Kind, link.Repo, link.URL, link.Error)
+	}
+
+	lr.mu.Lock()
+	lr.checked, lr.broken, lr.running = time.Now(), broken, false
+	lr.mu.Unlock()
+}
+
+// CheckLinksPeriodically runs RunLinkCheck every LinkCheckPeriod, forever.
+// A zero period disables the checks until the configuration is reloaded.
+func (sc *Smithy) CheckLinksPeriodically() {
+	for {
+		period := sc.Config().LinkCheckPeriod
+		if checked, _, _ := sc.links.Get(); period > 0 && time.Since(checked) >= period {
+			sc.RunLinkCheck()
+		}
+		time.Sleep(LINK_CHECK_INTERVAL)
+	}
+}
+
+// LinksView shows the broken links found by the last check. Posting starts
+// a new check in the background.
+func (sc *Smithy) LinksView(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		go sc.RunLinkCheck()
+		http.Redirect(w, r, "/admin/links", http.StatusSeeOther)
+		return
+	}
+	checked, broken, running := sc.links.Get()
+	sc.Render(w, r, "links", H{
+		"Checked": checked,
+		"Broken":  broken,
+		"Running": running,
+		"Period":  sc.Config().LinkCheckPeriod,
+	})
+}
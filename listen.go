@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SD_LISTEN_FDS_START is the first file descriptor passed by systemd
+// socket activation.
+const SD_LISTEN_FDS_START = 3
+
+// systemdListener returns the socket passed by systemd, if any.
+func systemdListener() (net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		log.Printf("systemd passed %d sockets, only the first is used", n)
+	}
+	f := os.NewFile(SD_LISTEN_FDS_START, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// Listen opens the listener of the HTTP server: the socket passed by
+// systemd socket activation, a unix socket for "unix:/path", or a TCP
+// address, which defaults to all interfaces on the configured port.
+func (sc *Smithy) Listen() (net.Listener, error) {
+	l, err := systemdListener()
+	if err != nil || l != nil {
+		return l, err
+	}
+	addr := sc.Config().Listen
+	if socket, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// A socket left behind by an earlier run would fail the bind.
+		if fi, err := os.Stat(socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(socket)
+		}
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("listen on %s: %v", addr, err)
+		}
+		return l, nil
+	}
+	if addr == "" {
+		addr = ":" + sc.Config().Port
+	}
+	return net.Listen("tcp", addr)
+}
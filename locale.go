@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// LocaleFormats are the layouts dates are shown with in a locale.
+type LocaleFormats struct {
+	Date     string
+	DateTime string
+}
+
+// LOCALE_FORMATS lists the locales pages can be formatted for. Numbers
+// follow the conventions of the locale, dates these layouts.
+var LOCALE_FORMATS = map[string]LocaleFormats{
+	"en":    {Date: "Jan 2, 2006", DateTime: "Jan 2, 2006 15:04"},
+	"en-GB": {Date: "2 Jan 2006", DateTime: "2 Jan 2006 15:04"},
+	"de":    {Date: "02.01.2006", DateTime: "02.01.2006 15:04"},
+	"es":    {Date: "02/01/2006", DateTime: "02/01/2006 15:04"},
+	"fr":    {Date: "02/01/2006", DateTime: "02/01/2006 15:04"},
+	"it":    {Date: "02/01/2006", DateTime: "02/01/2006 15:04"},
+	"nl":    {Date: "02-01-2006", DateTime: "02-01-2006 15:04"},
+	"pt":    {Date: "02/01/2006", DateTime: "02/01/2006 15:04"},
+	"ru":    {Date: "02.01.2006", DateTime: "02.01.2006 15:04"},
+	"ja":    {Date: "2006/01/02", DateTime: "2006/01/02 15:04"},
+	"ko":    {Date: "2006. 1. 2.", DateTime: "2006. 1. 2. 15:04"},
+	"zh":    {Date: "2006/1/2", DateTime: "2006/1/2 15:04"},
+}
+
+// Locale formats dates, numbers and sizes on pages.
+type Locale struct {
+	Name    string
+	formats LocaleFormats
+	printer *message.Printer
+}
+
+func NewLocale(name string) Locale {
+	return Locale{
+		Name:    name,
+		formats: LOCALE_FORMATS[name],
+		printer: message.NewPrinter(language.Make(name)),
+	}
+}
+
+// Locale picks the locale of LOCALE_FORMATS that best matches the
+// Accept-Language header of the request, or Config.Locale.
+func (sc *Smithy) Locale(r *http.Request) Locale {
+	fallback := sc.Config().Locale
+	if r == nil {
+		return NewLocale(fallback)
+	}
+	accepted, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(accepted) == 0 {
+		return NewLocale(fallback)
+	}
+	names := []string{fallback}
+	for name := range LOCALE_FORMATS {
+		if name != fallback {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	tags := make([]language.Tag, len(names))
+	for i, name := range names {
+		tags[i] = language.Make(name)
+	}
+	_, i, confidence := language.NewMatcher(tags).Match(accepted...)
+	if confidence == language.No {
+		return NewLocale(fallback)
+	}
+	return NewLocale(names[i])
+}
+
+func (l Locale) Date(t time.Time) string {
+	return t.Format(l.formats.Date)
+}
+
+func (l Locale) DateTime(t time.Time) string {
+	return t.Format(l.formats.DateTime)
+}
+
+// Number formats an integer with the digit grouping of the locale.
+func (l Locale) Number(n interface{}) string {
+	return l.printer.Sprint(n)
+}
+
+// Bytes formats a size in bytes with binary units.
+func (l Locale) Bytes(size int64) string {
+	if size < 1024 {
+		return l.printer.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	unit := ""
+	for _, u := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= 1024
+		unit = u
+		if value < 1024 {
+			break
+		}
+	}
+	return l.printer.Sprintf("%.1f %s", value, unit)
+}
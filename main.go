@@ -2,42 +2,301 @@ package main
 
 import (
 	"flag"
-	"net/http"
+	"fmt"
+	"log"
 	"os"
 	"path"
+	"runtime"
+	"strings"
+	"time"
 )
 
-func main() {
-	var port string
+// ParseConfig builds the configuration from command line arguments and the
+// config file they name. It runs again on reload, so it must not change
+// package state.
+func ParseConfig(args []string, errorHandling flag.ErrorHandling) (SmithyConfig, error) {
+	config := SmithyConfig{
+		Timeouts:  DurationMap{},
+		Aliases:   StringMap{},
+		Webhooks:  WebhookMap{},
+		Digests:   DigestMap{},
+		OIDC:      OIDCConfig{Groups: StringMap{}},
+		SAML:      SAMLConfig{Groups: StringMap{}},
+		ProxyAuth: ProxyAuthConfig{Groups: StringMap{}},
+		Users:     Credentials{},
+		Repos:     map[string]RepoOverride{},
+		Profiles:  map[string]UserProfile{},
+	}
 	home, _ := os.UserHomeDir()
-	root := path.Join(home, "Projects")
-	flag.StringVar(&root, "root", root, "repos root dir")
-	flag.StringVar(&port, "port", "3456", "listen port")
-	flag.Parse()
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	configFile := fs.String("config", "", "YAML config file, keyed by flag names; command line flags take precedence")
+	fs.StringVar(&config.Title, "title", "Projects", "instance title")
+	fs.StringVar(&config.Description, "description", "", "instance description")
+	fs.StringVar(&config.Announcement, "announcement", "", "Markdown banner shown on every page, e.g. a maintenance notice")
+	fs.StringVar(&config.Host, "host", "code.lsong.org", "public host name used in clone URLs")
+	fs.StringVar(&config.ExternalURL, "external-url", "", "URL clients reach smithy at, e.g. https://code.example.org, if it differs from the listen address")
+	fs.StringVar(&config.Root, "root", path.Join(home, "Projects"), "repos root dir")
+	fs.StringVar(&config.Port, "port", "3456", "listen port")
+	fs.StringVar(&config.Listen, "listen", "", "listen address instead of -port, e.g. 127.0.0.1:3456 or unix:/run/smithy.sock")
+	fs.StringVar(&config.GitDaemonAddr, "git-daemon", "", "serve git:// on this address, e.g. :9418")
+	fs.Int64Var(&config.UploadPackRate, "clone-rate", 0, "per-connection clone bandwidth cap in KiB/s")
+	fs.Int64Var(&config.UploadPackGlobalRate, "clone-rate-global", 0, "total clone bandwidth cap in KiB/s")
+	fs.IntVar(&config.RateLimit.Pages, "rate-limit", 0, "max page requests per minute from one IP, 0 for unlimited")
+	fs.IntVar(&config.RateLimit.Heavy, "rate-limit-heavy", 0, "max clones, archive downloads and log views per minute from one IP, 0 to count them against -rate-limit")
+	compressTypes := fs.String("compress", "text/html,text/plain,text/css,application/json,application/atom+xml,image/svg+xml", "content types of responses to compress, empty to disable")
+	fs.DurationVar(&config.PageCacheTTL, "page-cache-ttl", 5*time.Second, "how long popular pages are cached for anonymous visitors, 0 to disable")
+	fs.IntVar(&config.UserLimits.LogDepth, "log-depth", 0, "how many commits deep logged in users may page through logs, 0 for unlimited")
+	fs.IntVar(&config.AnonymousLimits.LogDepth, "anon-log-depth", 2000, "how many commits deep anonymous visitors may page through logs, 0 for unlimited")
+	fs.IntVar(&config.UserLimits.RangeSize, "range-max", 100, "max commits in a patch series for logged in users, 0 for unlimited")
+	fs.IntVar(&config.AnonymousLimits.RangeSize, "anon-range-max", 25, "max commits in a patch series for anonymous visitors, 0 for unlimited")
+	fs.IntVar(&config.HighlightMaxLines, "highlight-max-lines", 5000, "don't highlight files with more lines than this")
+	fs.StringVar(&config.Locale, "locale", "en", "locale for dates and numbers when the browser asks for none that is supported, e.g. en-GB or de")
+	fs.Int64Var(&config.MaxRenderSize, "max-render-size", 1024, "show at most this many KiB of a file on blob pages, 0 for no limit")
+	fs.StringVar(&config.StaticDir, "static", "", "directory of files served under /static/; logo.svg replaces the logo, custom.css is added to every page, icon.png, icon.svg and favicon.ico are served at the root")
+	fs.StringVar(&config.DataDir, "data", "", "data dir for release assets etc. (default <root>/.smithy)")
+	fs.Int64Var(&config.MaxAssetSize, "max-asset-size", 512, "max release asset upload size in MiB")
+	fs.BoolVar(&config.AllowNonBarePush, "push-non-bare", false, "allow pushing to non-bare repositories")
+	fs.IntVar(&config.MaxHeavyOperations, "max-heavy", runtime.NumCPU(), "max concurrent expensive operations")
+	fs.IntVar(&config.ObjectCacheSize, "object-cache", 96, "object cache size in MiB per repository")
+	fs.BoolVar(&config.Watch, "watch", true, "pick up repositories added to or removed from the root without a reload")
+	fs.IntVar(&config.MaxOpenRepos, "max-open-repos", 64, "how many repositories are kept open at once, 0 for all")
+	fs.IntVar(&config.RenderCacheSize, "render-cache", 32, "size in MiB of the cache of rendered READMEs and highlighted files")
+	fs.BoolVar(&config.SearchIndex, "search-index", false, "index code and commit messages of default branches for search")
+	fs.BoolVar(&config.SharedObjectCache, "object-cache-shared", false, "share a single object cache between all repositories")
+	fs.BoolVar(&config.ReplaceRefs, "replace-refs", true, "show history as rewritten by git replace and grafts")
+	fs.Var(config.Aliases, "alias", "repository aliases, e.g. old-name=new-name")
+	users := StringMap{}
+	fs.Var(users, "user", "users who can log in and push, e.g. alice=secret,bob=hunter2")
+	fs.Var(users, "push-user", "deprecated alias of -user")
+	htpasswd := fs.String("htpasswd", "", "htpasswd file with users who can log in and push")
+	fs.StringVar(&config.TLS.CertFile, "tls-cert", "", "serve HTTPS with this certificate file")
+	fs.StringVar(&config.TLS.KeyFile, "tls-key", "", "private key of -tls-cert")
+	fs.Var(&config.TLS.ACMEDomains, "acme-domains", "serve HTTPS with Let's Encrypt certificates for these domains, e.g. code.example.org")
+	fs.StringVar(&config.TLS.ACMEEmail, "acme-email", "", "contact address for the ACME account")
+	fs.StringVar(&config.TLS.ACMEHTTPAddr, "acme-http", ":80", "address answering ACME HTTP challenges and redirecting to HTTPS, empty to disable")
+	fs.DurationVar(&config.Server.ReadTimeout, "read-timeout", 0, "max time to read a request including its body, e.g. pushes, 0 for none")
+	fs.DurationVar(&config.Server.ReadHeaderTimeout, "read-header-timeout", 0, "max time to read request headers, 0 for -read-timeout")
+	fs.DurationVar(&config.Server.WriteTimeout, "write-timeout", 0, "max time to write a response, e.g. clones and archives, 0 for none")
+	fs.DurationVar(&config.Server.IdleTimeout, "idle-timeout", 0, "how long idle keep-alive connections are kept open, 0 for -read-timeout")
+	fs.IntVar(&config.Server.MaxHeaderBytes, "max-header-bytes", 0, "max size of request headers in bytes, 0 for 1 MiB")
+	fs.BoolVar(&config.Server.HTTP2, "http2", true, "offer HTTP/2 to HTTPS clients")
+	fs.StringVar(&config.AuthHook, "auth-hook", "", "command or http(s) URL deciding whether a user may read, push to or administer a repository")
+	fs.StringVar(&config.SiteUser, "basic-auth", "", "require this user:password, or those of a -user, for the whole site")
+	fs.StringVar(&config.OIDC.Issuer, "oidc-issuer", "", "OpenID Connect provider to log in with, e.g. https://sso.example.org/realms/dev")
+	fs.StringVar(&config.OIDC.ClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	fs.StringVar(&config.OIDC.ClientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	fs.StringVar(&config.OIDC.Scopes, "oidc-scopes", "openid profile email", "OpenID Connect scopes to request")
+	fs.Var(config.OIDC.Groups, "oidc-groups", "permissions granted to OpenID Connect groups, e.g. devs=push,ops=admin")
+	fs.StringVar(&config.SAML.IDPMetadata, "saml-idp-metadata", "", "URL or file of the SAML identity provider metadata to log in with")
+	fs.StringVar(&config.SAML.CertFile, "saml-cert", "", "certificate identifying smithy to the SAML identity provider")
+	fs.StringVar(&config.SAML.KeyFile, "saml-key", "", "RSA private key of -saml-cert")
+	fs.StringVar(&config.SAML.UserAttribute, "saml-user-attribute", "", "SAML attribute holding the user name, the NameID if empty")
+	fs.StringVar(&config.SAML.GroupsAttribute, "saml-groups-attribute", "groups", "SAML attribute listing the user's groups")
+	fs.Var(config.SAML.Groups, "saml-groups", "permissions granted to SAML groups, e.g. devs=push,ops=admin")
+	fs.StringVar(&config.ProxyAuth.UserHeader, "proxy-user-header", "", "trust this header set by a reverse proxy to name the user, e.g. Remote-User")
+	fs.StringVar(&config.ProxyAuth.GroupsHeader, "proxy-groups-header", "", "header set by the reverse proxy listing the user's groups, e.g. Remote-Groups")
+	fs.Var(&config.ProxyAuth.Trusted, "proxy-trusted", "networks of the reverse proxies trusted with -proxy-user-header and X-Forwarded-For (default loopback)")
+	fs.StringVar(&config.ProxyAuth.Permission, "proxy-permission", "read", "permission of every user named by the reverse proxy")
+	fs.Var(config.ProxyAuth.Groups, "proxy-groups", "permissions granted to reverse proxy groups, e.g. devs=push,ops=admin")
+	hiddenRefs := fs.String("hide-refs", "", "refs not to list in the UI, API and feeds, e.g. refs/heads/ci/*,refs/tags/nightly-*")
+	readmeNames := fs.String("readme", strings.Join(README_NAMES, ","), "README file names to look for, in order of preference")
+	markdownExtensions := fs.String("markdown-extensions", strings.Join(MARKDOWN_EXTENSIONS, ","), "Markdown extensions to enable: table, strikethrough, linkify, tasklist, footnote, definitionlist, typographer or gfm")
+	hashLength := fs.Int("hash-length", HASH_LENGTH, "shortest abbreviation of commit hashes, longer where needed to be unambiguous (4-40)")
+	fs.DurationVar(&config.TrashRetention, "trash-retention", 30*24*time.Hour, "how long deleted repositories are kept in the trash")
+	fs.DurationVar(&config.LinkCheckPeriod, "link-check-period", 0, "how often to check repository websites and mirror remotes, e.g. 24h, 0 to disable")
+	fs.Var(config.Webhooks, "webhook", "notify a URL of pushes to a repository, e.g. repo.git=https://ci.example.org/hook (repeatable)")
+	webhookSecret := fs.String("webhook-secret", "", "HMAC secret used to sign webhook payloads")
+	fs.StringVar(&config.Mail.SMTPAddr, "smtp", "", "mail server to send notifications through, e.g. mail.example.org:587")
+	fs.StringVar(&config.Mail.SMTPUser, "smtp-user", "", "user to authenticate to the mail server as")
+	fs.StringVar(&config.Mail.SMTPPassword, "smtp-password", "", "password of -smtp-user")
+	fs.StringVar(&config.Mail.From, "mail-from", "", "sender address of notifications")
+	fs.Var(config.Digests, "digest", "mail activity digests of a repository to an address, e.g. repo.git=alice@example.org (repeatable)")
+	digestPeriod := fs.String("digest-period", "daily", "how often digests are sent: daily, weekly or a duration")
+	fs.Var(config.Timeouts, "timeout", "per-operation time limits, e.g. default=30s,log=10s,diff=20s,import=1h")
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+	if *configFile != "" {
+		if err := LoadConfigFile(fs, *configFile, config.Repos, config.Profiles); err != nil {
+			return config, err
+		}
+	}
+	config.ReadmeNames = strings.Split(*readmeNames, ",")
+	if _, ok := LOCALE_FORMATS[config.Locale]; !ok {
+		return config, fmt.Errorf("-locale: unsupported locale %q", config.Locale)
+	}
+	config.HiddenRefs = strings.FieldsFunc(*hiddenRefs, func(c rune) bool { return c == ',' })
+	for _, pattern := range config.HiddenRefs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return config, fmt.Errorf("-hide-refs: %q: %v", pattern, err)
+		}
+	}
+	extensions := strings.FieldsFunc(*markdownExtensions, func(c rune) bool { return c == ',' })
+	for _, name := range extensions {
+		if _, ok := markdownExtenders[name]; !ok {
+			return config, fmt.Errorf("-markdown-extensions: unknown extension %q", name)
+		}
+	}
+	config.MarkdownExtensions = extensions
+	if *hashLength < 4 || *hashLength > 40 {
+		return config, fmt.Errorf("-hash-length must be between 4 and 40")
+	}
+	config.HashLength = *hashLength
+	period, err := ParseDigestPeriod(*digestPeriod)
+	if err != nil {
+		return config, fmt.Errorf("-digest-period: %v", err)
+	}
+	config.DigestPeriod = period
+	config.CompressTypes.Set(*compressTypes)
+	for group, permission := range config.OIDC.Groups {
+		if _, err := ParsePermission(permission); err != nil {
+			return config, fmt.Errorf("-oidc-groups %s: %v", group, err)
+		}
+	}
+	if len(config.ProxyAuth.Trusted) == 0 {
+		config.ProxyAuth.Trusted = StringList{"127.0.0.0/8", "::1/128"}
+	}
+	if _, err := ParsePermission(config.ProxyAuth.Permission); err != nil {
+		return config, fmt.Errorf("-proxy-permission: %v", err)
+	}
+	for group, permission := range config.ProxyAuth.Groups {
+		if _, err := ParsePermission(permission); err != nil {
+			return config, fmt.Errorf("-proxy-groups %s: %v", group, err)
+		}
+	}
+	if config.SAML.Enabled() && config.ExternalURL == "" {
+		return config, fmt.Errorf("-saml-idp-metadata needs -external-url")
+	}
+	for group, permission := range config.SAML.Groups {
+		if _, err := ParsePermission(permission); err != nil {
+			return config, fmt.Errorf("-saml-groups %s: %v", group, err)
+		}
+	}
+	for user, password := range users {
+		config.Users[user] = password
+	}
+	if *htpasswd != "" {
+		if err := config.Users.LoadHtpasswd(*htpasswd); err != nil {
+			return config, err
+		}
+	}
+	for repo, hooks := range config.Webhooks {
+		for i := range hooks {
+			config.Webhooks[repo][i].Secret = *webhookSecret
+		}
+	}
+	return config, nil
+}
 
-	sc := NewSmithy(root)
+func main() {
+	config, err := ParseConfig(os.Args[1:], flag.ExitOnError)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.ApplyGlobals()
+	sc := NewSmithy(config)
 	sc.LoadTemplates()
 	sc.LoadAllRepositories()
+	sc.RegisterWellKnown("webfinger", sc.WebFinger)
 
 	routes := []Route{
-		{pattern: r(`^/$`), handler: sc.IndexView},
+		{pattern: r(`^/$`), handler: sc.Cached(sc.IndexView, "collapsed")},
+		{pattern: r(`^/search$`), handler: sc.SearchView},
+		{pattern: r(`^/highlight\.css$`), handler: sc.HighlightCSS},
+		{pattern: r(`^/static/(?P<file>.+)$`), handler: sc.StaticView},
+		{pattern: r(`^/(?P<file>icon(?:-x[0-9]+)?\.(?:png|svg)|favicon\.ico|robots\.txt)$`), handler: sc.StaticView},
+		{pattern: r(`^/healthz$`), handler: sc.Healthz},
+		{pattern: r(`^/readyz$`), handler: sc.Readyz},
 		{pattern: r(`^/new$`), handler: sc.NewProject},
 		{pattern: r(`^/import$`), handler: sc.ImportProject},
 		{pattern: r(`^/reload$`), handler: sc.Reload},
-		{pattern: r(`^/(?P<repo>[^/]+)$`), handler: sc.RepoView},
-		{pattern: r(`^/(?P<repo>[^/]+)/refs$`), handler: sc.RefsView},
-		{pattern: r(`^/(?P<repo>[^/]+)/log$`), handler: sc.LogView},
-		{pattern: r(`^/(?P<repo>[^/]+)/log/(?P<ref>[^/]+)?$`), handler: sc.LogView},
-		{pattern: r(`^/(?P<repo>[^/]+)/patch/(?P<hash>[^/]+)$`), handler: sc.PatchView},
-		{pattern: r(`^/(?P<repo>[^/]+)/commit/(?P<hash>[^/]+)`), handler: sc.CommitView},
-		{pattern: r(`^/(?P<repo>[^/]+)/tree$`), handler: sc.TreeView},
-		{pattern: r(`^/(?P<repo>[^/]+)/tree/(?P<ref>[^/]+)$`), handler: sc.TreeView},
-		{pattern: r(`^/(?P<repo>[^/]+)/tree/(?P<ref>[^/]+)?/(?P<path>.*)`), handler: sc.TreeView},
-		{pattern: r(`^/(?P<repo>[^/]+)/info/refs$`), handler: sc.getInfoRefs},
-		{pattern: r(`^/(?P<repo>[^/]+)/git-upload-pack$`), handler: sc.uploadPack},
-		{pattern: r(`^/(?P<repo>[^/]+)/git-receive-pack$`), handler: sc.receivePack},
+		{pattern: r(`^/admin/reload$`), handler: sc.Reload},
+		{pattern: r(`^/login$`), handler: sc.LoginView},
+		{pattern: r(`^/logout$`), handler: sc.LogoutView},
+		{pattern: r(`^/login/oidc$`), handler: sc.OIDCLoginView},
+		{pattern: r(`^/login/oidc/callback$`), handler: sc.OIDCCallbackView},
+		{pattern: r(`^/login/saml$`), handler: sc.SAMLLoginView},
+		{pattern: r(`^/login/saml/acs$`), handler: sc.SAMLACSView},
+		{pattern: r(`^/login/saml/metadata$`), handler: sc.SAMLMetadataView},
+		{pattern: r(`^/\.well-known/(?P<name>[^/]*)$`), handler: sc.WellKnownView},
+		{pattern: r(`^/api/v1/stats$`), handler: sc.StatsAPI},
+		{pattern: r(`^/api/v1/markdown$`), handler: sc.MarkdownAPI},
+		{pattern: r(`^/api/v1/repos$`), handler: sc.APIRepos},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)$`), handler: sc.APIRepo},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/branches$`), handler: sc.APIBranches},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/tags$`), handler: sc.APITags},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/releases$`), handler: sc.APIReleases},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/commits/(?P<hash>[^/]+)$`), handler: sc.APICommit},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/commits/(?P<hash>[^/]+)/signature$`), handler: sc.APICommitSignature},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/tree/(?P<ref>[^/]+)(?:/(?P<path>.*))?$`), handler: sc.APITree},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/languages$`), handler: sc.APILanguages},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/activity$`), handler: sc.APIActivity},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/files/(?P<ref>[^/]+)$`), handler: sc.APIFiles},
+		{pattern: r(`^/api/v1/repos/(?P<repo>[^/]+(?:/[^/]+)*?)/blob/(?P<ref>[^/]+)/(?P<path>.+)$`), handler: sc.APIBlob},
+		{pattern: r(`^/admin/backup/(?P<repo>[^/]+(?:/[^/]+)*?)$`), handler: sc.BackupView},
+		{pattern: r(`^/admin/trash$`), handler: sc.TrashView},
+		{pattern: r(`^/admin/trash/(?P<id>[^/]+)/restore$`), handler: sc.RestoreView},
+		{pattern: r(`^/admin/transfer$`), handler: sc.TransferView},
+		{pattern: r(`^/admin/announcement$`), handler: sc.AnnouncementView},
+		{pattern: r(`^/admin/links$`), handler: sc.LinksView},
+		{pattern: r(`^/~(?P<user>[^/]+)\.(?P<kind>keys|gpg)$`), handler: sc.UserKeysView},
+		{pattern: r(`^/~(?P<user>[^/]+)$`), handler: sc.ProfileView},
+		{pattern: r(`^/~(?P<user>[^/]+)/watching\.atom$`), handler: sc.WatchFeedView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)$`), handler: sc.Cached(sc.RepoView)},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/refs$`), handler: sc.Cached(sc.RefsView)},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/(?P<action>star|watch)$`), handler: sc.ListView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/tags\.atom$`), handler: sc.TagFeedView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/badge/(?P<kind>[a-z]+)\.svg$`), handler: sc.BadgeView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/releases$`), handler: sc.ReleasesView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/contributors$`), handler: sc.ContributorsView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/releases/(?P<ref>.+)/upload$`), handler: sc.UploadReleaseAsset},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/releases/(?P<ref>.+)/(?P<file>[^/]+)$`), handler: sc.ReleaseAssetView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/log$`), handler: sc.LogView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/log/(?P<ref>[^/]+)/(?P<path>.+)\.atom$`), handler: sc.LogFeedView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/log/(?P<ref>[^/]+)\.atom$`), handler: sc.LogFeedView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/log/(?P<ref>[^/]+)?$`), handler: sc.LogView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/log/(?P<ref>[^/]+)/(?P<path>.+)$`), handler: sc.LogView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/archive/(?P<ref>[^/]+)\.(?P<format>tar\.gz|zip)(?P<sum>\.sha256)?$`), handler: sc.ArchiveView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/patch/(?P<from>[^/.]+)\.\.(?P<hash>[^/.]+)(?:\.mbox)?$`), handler: sc.PatchSeriesView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/patch/(?P<hash>[^/.]+)(?P<format>\.patch|\.diff)?$`), handler: sc.PatchView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/commit/(?P<hash>[^/.]+)\.patch\.html$`), handler: sc.PatchHTMLView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/commit/(?P<hash>[^/.]+)\.(?P<format>patch|diff)$`), handler: sc.PatchView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/commit/(?P<hash>[^/]+)`), handler: sc.CommitView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/compare$`), handler: sc.CompareView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/compare/(?P<base>.+?)\.\.\.(?P<head>.+)$`), handler: sc.CompareView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/tree$`), handler: sc.Cached(sc.TreeView, "page")},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/tree/(?P<ref>[^/]+)$`), handler: sc.Cached(sc.TreeView, "page")},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/find/(?P<ref>[^/]+)$`), handler: sc.FindView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/tree/(?P<ref>[^/]+)?/(?P<path>.*)`), handler: sc.TreeView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/raw/(?P<ref>[^/]+)/(?P<path>.+)$`), handler: sc.RawView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/blame/(?P<ref>[^/]+)/(?P<path>.+)$`), handler: sc.BlameView},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/info/refs$`), handler: sc.getInfoRefs},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/git-upload-pack$`), handler: sc.uploadPack},
+		{pattern: r(`^/(?P<repo>[^/]+(?:/[^/]+)*?)/git-receive-pack$`), handler: sc.receivePack},
+	}
+
+	go sc.PurgeTrashPeriodically(time.Hour)
+	go sc.SendDigestsPeriodically()
+	go sc.CheckLinksPeriodically()
+	go sc.IndexAllRepositories()
+	go sc.ReloadOnSignal()
+	if config.Watch {
+		if err := sc.WatchRoot(); err != nil {
+			log.Printf("not watching %s: %v", config.Root, err)
+		}
+	}
+
+	if config.GitDaemonAddr != "" {
+		if config.SiteUser != "" {
+			log.Printf("warning: the git daemon is not covered by -basic-auth")
+		}
+		go func() {
+			log.Fatal(sc.ServeGitDaemon(config.GitDaemonAddr))
+		}()
 	}
 
 	router := NewRouter(routes)
-	http.ListenAndServe(":"+port, router)
+	router.HasRepo = sc.RepoExists
+	log.Fatal(sc.ListenAndServe(sc.RateLimit(sc.Compress(sc.SiteAuth(sc.GoImport(sc.RedirectAliases(sc.ProtectPrivate(router))))))))
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{nil, ""},
+		{[]string{"-locale", "de"}, ""},
+		{[]string{"-locale", "xx"}, "-locale"},
+		{[]string{"-hide-refs", "refs/heads/ci/*,refs/tags/nightly-*"}, ""},
+		{[]string{"-hide-refs", "refs/heads/["}, "-hide-refs"},
+		{[]string{"-markdown-extensions", "table,gfm"}, ""},
+		{[]string{"-markdown-extensions", "table,mermaid"}, "-markdown-extensions"},
+		{[]string{"-hash-length", "3"}, "-hash-length"},
+		{[]string{"-hash-length", "41"}, "-hash-length"},
+		{[]string{"-digest-period", "weekly"}, ""},
+		{[]string{"-digest-period", "sometimes"}, "-digest-period"},
+		{[]string{"-oidc-groups", "devs=push"}, ""},
+		{[]string{"-oidc-groups", "devs=root"}, "-oidc-groups"},
+		{[]string{"-proxy-permission", "owner"}, "-proxy-permission"},
+		{[]string{"-proxy-groups", "ops=root"}, "-proxy-groups"},
+		{[]string{"-saml-idp-metadata", "idp.xml"}, "-external-url"},
+		{[]string{"-saml-idp-metadata", "idp.xml", "-external-url", "https://code.example.org"}, ""},
+		{[]string{"-saml-idp-metadata", "idp.xml", "-external-url", "https://code.example.org", "-saml-groups", "devs=root"}, "-saml-groups"},
+		{[]string{"-timeout", "log"}, "expected name=duration"},
+	}
+	for _, tt := range tests {
+		_, err := ParseConfig(tt.args, flag.ContinueOnError)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("ParseConfig(%q): %v", tt.args, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("ParseConfig(%q) error = %v, want one mentioning %q", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+	config, err := ParseConfig([]string{"-timeout", "default=10s,log=5s", "-webhook", "project=https://ci.example.org/hook", "-webhook-secret", "s3cret"}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(config.ProxyAuth.Trusted, ","); got != "127.0.0.0/8,::1/128" {
+		t.Errorf("trusted proxies = %q", got)
+	}
+	timeouts := map[string]time.Duration{
+		"log":    5 * time.Second,
+		"diff":   10 * time.Second,
+		"import": time.Hour,
+	}
+	for op, want := range timeouts {
+		if got := config.Timeout(op); got != want {
+			t.Errorf("Timeout(%q) = %v, want %v", op, got, want)
+		}
+	}
+	if hooks := config.Webhooks["project"]; len(hooks) != 1 || hooks[0].Secret != "s3cret" {
+		t.Errorf("webhooks = %+v", hooks)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// MARKDOWN_API_MAX limits the size of documents rendered by the API.
+var MARKDOWN_API_MAX int64 = 1 << 20
+
+// linkRewriter points relative links of a document in the directory dir
+// of a tree at the tree view of their targets, and relative images at
+// their raw contents.
+type linkRewriter struct {
+	treeURL string
+	dir     string
+}
+
+func (lr linkRewriter) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = []byte(lr.resolve(lr.treeURL, string(n.Destination)))
+		case *ast.Image:
+			n.Destination = []byte(lr.resolve(rawURL(lr.treeURL), string(n.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func (lr linkRewriter) resolve(base, dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return dest
+	}
+	// Links climbing above the root stay in the tree.
+	u.Path = strings.TrimSuffix(base, "/") + path.Join("/", lr.dir, u.Path)
+	return u.String()
+}
+
+// rawURL turns /repo/tree/ref into /repo/raw/ref. Refs in these URLs hold
+// no slashes, so the last /tree/ is the one before the ref.
+func rawURL(treeURL string) string {
+	i := strings.LastIndex(treeURL, "/tree/")
+	if i < 0 {
+		return treeURL
+	}
+	return treeURL[:i] + "/raw/" + treeURL[i+len("/tree/"):]
+}
+
+// MarkdownRequest is the body of a markdown API request. With a repository,
+// relative links resolve as for a document at Path on Ref, which defaults to
+// the default branch.
+type MarkdownRequest struct {
+	Text string `json:"text"`
+	Repo string `json:"repo"`
+	Ref  string `json:"ref"`
+	Path string `json:"path"`
+}
+
+// MarkdownAPI renders Markdown to HTML the way documents in repositories
+// are rendered.
+func (sc *Smithy) MarkdownAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		sc.JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+	var req MarkdownRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MARKDOWN_API_MAX)).Decode(&req); err != nil {
+		sc.JSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	var treeURL string
+	if req.Repo != "" {
+		repo, exists := sc.FindRepo(req.Repo)
+		if !exists || !sc.CanRead(r, repo) {
+			sc.JSONError(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+			return
+		}
+		ref := req.Ref
+		if ref == "" {
+			ref, _, _ = sc.MainBranch(repo)
+		}
+		treeURL = fmt.Sprintf("/%s/tree/%s", repo.Name, ref)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, FormatMarkdown(req.Text, treeURL, req.Path))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	OIDC_STATE_COOKIE   = "smithy_oidc"
+	OIDC_STATE_LIFETIME = 10 * time.Minute
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+type OIDCConfig struct {
+	// Issuer is the provider URL, e.g. https://sso.example.org/realms/dev.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	// Groups maps group names of the "groups" claim to permissions.
+	// Users in none of them may only read.
+	Groups StringMap
+}
+
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+// Permission returns the highest permission granted by groups.
+func (c OIDCConfig) Permission(groups []string) Permission {
+	return GroupPermission(c.Groups, groups)
+}
+
+type oidcProvider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// OIDCDiscovery fetches and remembers the provider's endpoints. They are
+// fetched again when the issuer changes on reload.
+type OIDCDiscovery struct {
+	mu       sync.Mutex
+	issuer   string
+	provider *oidcProvider
+}
+
+func (d *OIDCDiscovery) Get(ctx context.Context, issuer string) (*oidcProvider, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.provider != nil && d.issuer == issuer {
+		return d.provider, nil
+	}
+	var provider oidcProvider
+	err := oidcGetJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", "", &provider)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %v", err)
+	}
+	d.issuer = issuer
+	d.provider = &provider
+	return d.provider, nil
+}
+
+func oidcGetJSON(ctx context.Context, endpoint, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return oidcDo(req, v)
+}
+
+func oidcDo(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", req.URL.Redacted(), resp.Status, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (sc *Smithy) oidcRedirectURI(r *http.Request) string {
+	return sc.BaseURL(r) + "/login/oidc/callback"
+}
+
+// OIDCLoginView sends the browser to the provider. The state, and where to
+// go after logging in, are kept in a signed cookie.
+func (sc *Smithy) OIDCLoginView(w http.ResponseWriter, r *http.Request) {
+	if !sc.Config().OIDC.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	provider, err := sc.oidc.Get(r.Context(), sc.Config().OIDC.Issuer)
+	if err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := hex.EncodeToString(b)
+	payload := state + "|" + localRedirect(r.FormValue("next"))
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    url.QueryEscape(payload + "|" + sc.signSession(payload)),
+		Path:     "/login/oidc",
+		MaxAge:   int(OIDC_STATE_LIFETIME.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(sc.BaseURL(r), "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {sc.Config().OIDC.ClientID},
+		"redirect_uri":  {sc.oidcRedirectURI(r)},
+		"scope":         {sc.Config().OIDC.Scopes},
+		"state":         {state},
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+"?"+query.Encode(), http.StatusFound)
+}
+
+// oidcState returns the state and next location saved by OIDCLoginView.
+func (sc *Smithy) oidcState(r *http.Request) (string, string, bool) {
+	cookie, err := r.Cookie(OIDC_STATE_COOKIE)
+	if err != nil {
+		return "", "", false
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return "", "", false
+	}
+	i := strings.LastIndex(value, "|")
+	if i < 0 || !sc.checkSession(value[:i], value[i+1:]) {
+		return "", "", false
+	}
+	state, next, _ := strings.Cut(value[:i], "|")
+	return state, next, true
+}
+
+// OIDCCallbackView completes the authorization code flow and logs the user
+// in with the permission granted by their groups.
+func (sc *Smithy) OIDCCallbackView(w http.ResponseWriter, r *http.Request) {
+	if !sc.Config().OIDC.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	state, next, ok := sc.oidcState(r)
+	if !ok || state == "" || r.FormValue("state") != state {
+		sc.Error(w, http.StatusBadRequest, fmt.Errorf("Invalid login state, please try again"))
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		sc.Error(w, http.StatusUnauthorized, fmt.Errorf("Login failed: %s %s", e, r.FormValue("error_description")))
+		return
+	}
+	ctx := r.Context()
+	provider, err := sc.oidc.Get(ctx, sc.Config().OIDC.Issuer)
+	if err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {r.FormValue("code")},
+		"redirect_uri": {sc.oidcRedirectURI(r)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(sc.Config().OIDC.ClientID), url.QueryEscape(sc.Config().OIDC.ClientSecret))
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := oidcDo(req, &token); err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+
+	// The userinfo endpoint is asked over TLS with the fresh access token,
+	// which spares verifying the ID token signature.
+	var claims struct {
+		Subject           string   `json:"sub"`
+		PreferredUsername string   `json:"preferred_username"`
+		Email             string   `json:"email"`
+		Groups            []string `json:"groups"`
+	}
+	if err := oidcGetJSON(ctx, provider.UserinfoEndpoint, token.AccessToken, &claims); err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+	user := claims.PreferredUsername
+	if user == "" {
+		user = claims.Email
+	}
+	if user == "" {
+		user = claims.Subject
+	}
+	if user == "" {
+		sc.Error(w, http.StatusBadGateway, fmt.Errorf("The identity provider did not name the user"))
+		return
+	}
+	permission := sc.Config().OIDC.Permission(claims.Groups)
+	log.Printf("OIDC login of %s with permission %d", user, permission)
+
+	http.SetCookie(w, &http.Cookie{Name: OIDC_STATE_COOKIE, Path: "/login/oidc", MaxAge: -1})
+	http.SetCookie(w, sc.NewSessionCookie(r, SESSION_OIDC, permission, user))
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+)
+
+var (
+	// PAGE_CACHE_STALE is how long after its TTL a cached page may still
+	// be served while it is rendered again in the background.
+	PAGE_CACHE_STALE = time.Minute
+	// PAGE_CACHE_SIZE is how many bytes of pages are kept at most. The
+	// least recently used ones are evicted first.
+	PAGE_CACHE_SIZE = 32 << 20
+)
+
+type cachedPage struct {
+	header     http.Header
+	body       []byte
+	rendered   time.Time
+	refreshing bool
+}
+
+// PageCache keeps rendered pages for anonymous visitors, so that bursts of
+// traffic to the same few pages are answered without opening repositories.
+type PageCache struct {
+	mu    sync.Mutex
+	pages *lru.Cache
+	size  int
+}
+
+func NewPageCache() *PageCache {
+	pc := &PageCache{pages: lru.New(0)}
+	pc.pages.OnEvicted = func(key lru.Key, value interface{}) {
+		pc.size -= len(value.(*cachedPage).body)
+	}
+	return pc
+}
+
+// Purge forgets all pages, e.g. after a push.
+func (pc *PageCache) Purge() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.pages.Clear()
+}
+
+// get returns the cached page for key. pc.mu must be held.
+func (pc *PageCache) get(key string) (*cachedPage, bool) {
+	page, ok := pc.pages.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return page.(*cachedPage), true
+}
+
+// pageRecorder captures a response to be cached.
+type pageRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (pr *pageRecorder) Header() http.Header {
+	return pr.header
+}
+
+func (pr *pageRecorder) WriteHeader(status int) {
+	if pr.status == 0 {
+		pr.status = status
+	}
+}
+
+func (pr *pageRecorder) Write(b []byte) (int, error) {
+	pr.WriteHeader(http.StatusOK)
+	return pr.body.Write(b)
+}
+
+// render runs the handler and stores its response if it can be reused.
+func (pc *PageCache) render(key string, handler http.HandlerFunc, r *http.Request) *pageRecorder {
+	rec := &pageRecorder{header: make(http.Header)}
+	handler(rec, r)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.pages.Remove(key)
+	if rec.status == http.StatusOK && rec.header.Get("Set-Cookie") == "" && rec.body.Len() <= PAGE_CACHE_SIZE {
+		pc.pages.Add(key, &cachedPage{header: rec.header, body: rec.body.Bytes(), rendered: time.Now()})
+		pc.size += rec.body.Len()
+		for pc.size > PAGE_CACHE_SIZE {
+			pc.pages.RemoveOldest()
+		}
+	}
+	return rec
+}
+
+func writePage(w http.ResponseWriter, r *http.Request, header http.Header, status int, body []byte) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	if etag := header.Get("ETag"); status == http.StatusOK && etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// pageRequest returns r with only the query parameters the view reads, so
+// that pages are cached by those alone: other parameters neither bypass the
+// cache nor fill it with copies of the same page.
+func pageRequest(r *http.Request, params []string) *http.Request {
+	query := r.URL.Query()
+	kept := url.Values{}
+	for _, name := range params {
+		if values, ok := query[name]; ok {
+			kept[name] = values
+		}
+	}
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = kept.Encode()
+	return r
+}
+
+// Cached serves anonymous GET requests for the handler from the page cache
+// for up to Config.PageCacheTTL. Older pages are served for another
+// PAGE_CACHE_STALE while a single background request renders them again.
+// params names the query parameters the handler reads.
+func (sc *Smithy) Cached(handler http.HandlerFunc, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl := sc.Config().PageCacheTTL
+		if ttl <= 0 || r.Method != http.MethodGet || sc.CurrentUser(r) != "" {
+			handler(w, r)
+			return
+		}
+		r = pageRequest(r, params)
+		key := sc.Locale(r).Name + " " + r.URL.RequestURI()
+
+		pc := sc.pages
+		pc.mu.Lock()
+		page, ok := pc.get(key)
+		var age time.Duration
+		if ok {
+			age = time.Since(page.rendered)
+		}
+		switch {
+		case ok && age < ttl:
+			pc.mu.Unlock()
+			writePage(w, r, page.header, http.StatusOK, page.body)
+			return
+		case ok && age < ttl+PAGE_CACHE_STALE:
+			if !page.refreshing {
+				page.refreshing = true
+				params := r.Context().Value(ParamsKey)
+				background := r.Clone(context.WithValue(context.Background(), ParamsKey, params))
+				go pc.render(key, handler, background)
+			}
+			pc.mu.Unlock()
+			writePage(w, r, page.header, http.StatusOK, page.body)
+			return
+		}
+		pc.mu.Unlock()
+
+		rec := pc.render(key, handler, r)
+		writePage(w, r, rec.header, rec.status, rec.body.Bytes())
+	}
+}
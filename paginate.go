@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+)
+
+// FLUSH_SIZE is how many bytes of a rendered page are buffered before they
+// are flushed to the client.
+var FLUSH_SIZE = 32 * 1024
+
+type Pagination struct {
+	Page    int
+	PerPage int
+	Total   int
+}
+
+func NewPagination(total, perPage, page int) Pagination {
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	if p.Page > p.Pages() {
+		p.Page = p.Pages()
+	}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	return p
+}
+
+func (p Pagination) Pages() int {
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+func (p Pagination) HasPrev() bool { return p.Page > 1 }
+func (p Pagination) HasNext() bool { return p.Page < p.Pages() }
+func (p Pagination) PrevPage() int { return p.Page - 1 }
+func (p Pagination) NextPage() int { return p.Page + 1 }
+
+// Bounds returns the slice indices of the current page.
+func (p Pagination) Bounds() (int, int) {
+	start := (p.Page - 1) * p.PerPage
+	if start > p.Total {
+		start = p.Total
+	}
+	end := start + p.PerPage
+	if end > p.Total {
+		end = p.Total
+	}
+	return start, end
+}
+
+// FlushWriter flushes the underlying ResponseWriter every time more than
+// size bytes have been written, so large pages reach the client in chunks
+// instead of all at once.
+type FlushWriter struct {
+	w       http.ResponseWriter
+	size    int
+	written int
+}
+
+func NewFlushWriter(w http.ResponseWriter, size int) *FlushWriter {
+	return &FlushWriter{w: w, size: size}
+}
+
+func (fw *FlushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.written += n
+	if fw.written >= fw.size {
+		if f, ok := fw.w.(http.Flusher); ok {
+			f.Flush()
+		}
+		fw.written = 0
+	}
+	return n, err
+}
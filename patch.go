@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// PATCH_NAME_MAX limits the subject part of patch file names, as in git
+// format-patch.
+var PATCH_NAME_MAX = 64
+
+// PatchFileName names a patch like git format-patch does, e.g.
+// 0001-Fix-the-thing.patch.
+func PatchFileName(n int, message string) string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	var sb strings.Builder
+	dash := false
+	for _, c := range subject {
+		if c < 128 && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '.' || c == '_') {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			dash = false
+			sb.WriteRune(c)
+		} else {
+			dash = true
+		}
+		if sb.Len() >= PATCH_NAME_MAX {
+			break
+		}
+	}
+	name := strings.Trim(sb.String(), ".-")
+	if len(name) > PATCH_NAME_MAX {
+		name = name[:PATCH_NAME_MAX]
+	}
+	return fmt.Sprintf("%04d-%s.patch", n, name)
+}
+
+// PatchHeader numbers a patch within a series and threads it: every patch
+// but the first replies to the first one.
+type PatchHeader struct {
+	N, M      int
+	MessageID string
+	InReplyTo string
+}
+
+// CommitPatch returns the changes of a commit against its first parent.
+func CommitPatch(ctx context.Context, commit *object.Commit) (*object.Patch, error) {
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	return parent.PatchContext(ctx, commit)
+}
+
+// FormatPatch renders a commit as a mail, like git format-patch.
+func FormatPatch(ctx context.Context, commit *object.Commit, header PatchHeader) (string, error) {
+	patch, err := CommitPatch(ctx, commit)
+	if err != nil {
+		return "", err
+	}
+	stats, err := commit.StatsContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	const commitFormatDate = "Mon, 2 Jan 2006 15:04:05 -0700"
+	prefix := "[PATCH]"
+	if header.M > 1 {
+		prefix = fmt.Sprintf("[PATCH %d/%d]", header.N, header.M)
+	}
+	subject, body, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From %s Mon Sep 17 00:00:00 2001\n", commit.Hash)
+	fmt.Fprintf(&sb, "From: %s <%s>\n", commit.Author.Name, commit.Author.Email)
+	fmt.Fprintf(&sb, "Date: %s\n", commit.Author.When.Format(commitFormatDate))
+	fmt.Fprintf(&sb, "Subject: %s %s\n", prefix, subject)
+	if header.MessageID != "" {
+		fmt.Fprintf(&sb, "Message-Id: <%s>\n", header.MessageID)
+	}
+	if header.InReplyTo != "" {
+		fmt.Fprintf(&sb, "In-Reply-To: <%s>\n", header.InReplyTo)
+		fmt.Fprintf(&sb, "References: <%s>\n", header.InReplyTo)
+	}
+	fmt.Fprintf(&sb, "\n")
+	if body = strings.TrimSpace(body); body != "" {
+		fmt.Fprintf(&sb, "%s\n", body)
+	}
+	fmt.Fprintf(&sb, "---\n%s\n%s", stats.String(), patch.String())
+	return sb.String(), nil
+}
+
+// PatchFile is a file changed by a patch, and the line of the patch its
+// diff starts at.
+type PatchFile struct {
+	Name string
+	Line int
+}
+
+// PatchFiles lists the files a formatted patch changes.
+func PatchFiles(patch string) []PatchFile {
+	var files []PatchFile
+	for i, line := range strings.Split(patch, "\n") {
+		if rest, ok := strings.CutPrefix(line, "diff --git "); ok {
+			name := rest
+			if j := strings.Index(rest, " b/"); j >= 0 {
+				name = rest[j+len(" b/"):]
+			}
+			files = append(files, PatchFile{Name: name, Line: i + 1})
+		}
+	}
+	return files
+}
+
+// PatchSeries returns the non-merge commits reachable from to but not from
+// from, oldest first, following first parents.
+func PatchSeries(to *object.Commit, from plumbing.Hash, max int) ([]*object.Commit, error) {
+	var series []*object.Commit
+	commit := to
+	for commit.Hash != from {
+		if max > 0 && len(series) >= max {
+			return nil, fmt.Errorf("Too many commits in range, at most %d are allowed", max)
+		}
+		if commit.NumParents() == 0 {
+			return nil, fmt.Errorf("%s is not an ancestor of %s", from, to.Hash)
+		}
+		if commit.NumParents() == 1 {
+			series = append(series, commit)
+		}
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		commit = parent
+	}
+	for i, j := 0, len(series)-1; i < j; i, j = i+1, j-1 {
+		series[i], series[j] = series[j], series[i]
+	}
+	return series, nil
+}
+
+// PatchSeriesView serves the commits of a range as a numbered, threaded
+// mbox that git am can apply.
+func (sc *Smithy) PatchSeriesView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	from, err := ResolveCommit(repo.Repository, sc.GetParam(r, "from"))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	to, err := ResolveCommit(repo.Repository, sc.GetParam(r, "hash"))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	immutable := from.Hash.String() == sc.GetParam(r, "from") && to.Hash.String() == sc.GetParam(r, "hash")
+	if sc.NotModified(w, r, immutable, "series", from.Hash.String(), to.Hash.String()) {
+		return
+	}
+	series, err := PatchSeries(to, from.Hash, sc.HistoryLimits(r).RangeSize)
+	if err != nil {
+		sc.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(series) == 0 {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("No commits in range"))
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "diff")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	var mbox strings.Builder
+	var first string
+	for i, commit := range series {
+		header := PatchHeader{
+			N:         i + 1,
+			M:         len(series),
+			MessageID: fmt.Sprintf("%s.%d@%s", commit.Hash, i+1, sc.Config().Host),
+			InReplyTo: first,
+		}
+		if i == 0 {
+			first = header.MessageID
+		}
+		patch, err := FormatPatch(ctx, commit, header)
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, contextError(ctx, err))
+			return
+		}
+		mbox.WriteString(patch)
+		mbox.WriteString("\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%s.mbox"`,
+		from.Hash.String()[:7], to.Hash.String()[:7]))
+	fmt.Fprint(w, mbox.String())
+}
+
+// PatchHTMLView renders the patch of a commit as a highlighted page, with an
+// anchor for every line and an index of the changed files, to link to from
+// reviews on mailing lists.
+func (sc *Smithy) PatchHTMLView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	commit, err := ResolveCommit(repo.Repository, sc.GetParam(r, "hash"))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	if commit.NumParents() == 0 {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Commit Parents not found"))
+		return
+	}
+	immutable := commit.Hash.String() == sc.GetParam(r, "hash")
+	if sc.NotModified(w, r, immutable, "patch.html", commit.Hash.String()) {
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "diff")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	patch, err := FormatPatch(ctx, commit, PatchHeader{N: 1, M: 1})
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, contextError(ctx, err))
+		return
+	}
+	highlighted, err := RenderSyntaxHighlighting("commit.patch", patch, HighlightOptions{
+		MaxLines: sc.Config().HighlightMaxLines,
+	})
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.Render(w, r, "patch", H{
+		"RepoName": repoName,
+		"Repo":     repo,
+		"Commit":   commit,
+		"FileName": PatchFileName(1, commit.Message),
+		"Files":    PatchFiles(patch),
+		"Patch":    template.HTML(highlighted.HTML),
+		"Reason":   highlighted.Reason,
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// PREFS_COOKIE stores display preferences so they stick across pages
+// without client-side scripts.
+const PREFS_COOKIE = "smithy_prefs"
+
+type Preferences struct {
+	// Wrap soft-wraps long lines in blob views.
+	Wrap bool
+	// Whitespace shows markers for spaces and tabs in blob views.
+	Whitespace bool
+}
+
+func (p Preferences) encode() string {
+	v := url.Values{}
+	if p.Wrap {
+		v.Set("wrap", "1")
+	}
+	if p.Whitespace {
+		v.Set("ws", "1")
+	}
+	return v.Encode()
+}
+
+// GetPreferences reads the preferences cookie, applies any preferences given
+// in the query string and stores them back if they changed.
+func (sc *Smithy) GetPreferences(w http.ResponseWriter, r *http.Request) Preferences {
+	var prefs Preferences
+	if c, err := r.Cookie(PREFS_COOKIE); err == nil {
+		if v, err := url.ParseQuery(c.Value); err == nil {
+			prefs.Wrap = v.Get("wrap") == "1"
+			prefs.Whitespace = v.Get("ws") == "1"
+		}
+	}
+
+	before := prefs
+	query := r.URL.Query()
+	if query.Has("wrap") {
+		prefs.Wrap = query.Get("wrap") == "1"
+	}
+	if query.Has("ws") {
+		prefs.Whitespace = query.Get("ws") == "1"
+	}
+	if prefs != before {
+		http.SetCookie(w, &http.Cookie{
+			Name:     PREFS_COOKIE,
+			Value:    prefs.encode(),
+			Path:     "/",
+			Expires:  time.Now().AddDate(1, 0, 0),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return prefs
+}
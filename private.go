@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CanRead reports whether the request may see the repository.
+// When an auth hook is configured it decides for every repository.
+func (sc *Smithy) CanRead(r *http.Request, repo RepositoryWithName) bool {
+	if sc.Config().AuthHook != "" {
+		user, _ := sc.Identify(r)
+		return sc.AuthHookAllows(user, repo.Name, PermissionRead)
+	}
+	if !sc.RepoSettings(repo).Private {
+		return true
+	}
+	_, permission := sc.Identify(r)
+	return permission >= PermissionRead
+}
+
+// UserCanRead reports whether a user may read a repository outside of a
+// request of theirs. The permissions of OIDC, SAML and proxy users are only
+// known while they make requests, so only local users may read private
+// repositories here.
+func (sc *Smithy) UserCanRead(user string, repo RepositoryWithName) bool {
+	if sc.Config().AuthHook != "" {
+		return sc.AuthHookAllows(user, repo.Name, PermissionRead)
+	}
+	if !sc.RepoSettings(repo).Private {
+		return true
+	}
+	_, local := sc.Config().Users[user]
+	return local
+}
+
+// VisibleRepositories returns the repositories the request may see.
+func (sc *Smithy) VisibleRepositories(r *http.Request) []RepositoryWithName {
+	var repos []RepositoryWithName
+	for _, repo := range sc.GetRepositories() {
+		if sc.CanRead(r, repo) {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// requestRepoName returns the name of the repository a request path refers
+// to, if any.
+func (sc *Smithy) requestRepoName(urlPath string) string {
+	for _, prefix := range []string{"/api/v1/repos/", "/admin/backup/"} {
+		if rest, ok := strings.CutPrefix(urlPath, prefix); ok {
+			name, _ := splitRepoPath(rest, sc.RepoExists)
+			return name
+		}
+	}
+	name, _ := splitRepoPath(strings.TrimPrefix(urlPath, "/"), sc.RepoExists)
+	return name
+}
+
+// ProtectPrivate hides private repositories from anonymous requests. Web
+// pages answer 404 as if the repository did not exist, while git clients
+// are asked for credentials.
+func (sc *Smithy) ProtectPrivate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := sc.requestRepoName(r.URL.Path)
+		repo, exists := sc.FindRepo(name)
+		if !exists || sc.CanRead(r, repo) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isGitRequest(r) {
+			sc.RequestAuth(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
+
+func isGitRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/info/refs") ||
+		strings.HasSuffix(r.URL.Path, "/git-upload-pack") ||
+		strings.HasSuffix(r.URL.Path, "/git-receive-pack")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/golang/groupcache/lru"
+)
+
+var (
+	// PROFILE_COMMITS is how many recent commits a profile page lists.
+	PROFILE_COMMITS = 30
+	// PROFILE_WALK_DEPTH is how many commits of each repository are looked
+	// through for commits by the user.
+	PROFILE_WALK_DEPTH = 500
+	// PROFILE_CACHE_SIZE is how many repositories' recent authors are
+	// remembered.
+	PROFILE_CACHE_SIZE = 256
+)
+
+// UserProfile is what the config file tells about a user.
+type UserProfile struct {
+	Name string `yaml:"name"`
+	// Emails attribute commits to the user.
+	Emails  []string `yaml:"emails"`
+	SSHKeys []string `yaml:"ssh_keys"`
+	GPGKeys []string `yaml:"gpg_keys"`
+}
+
+// ProfileCommit is a commit on a profile page, with its repository.
+type ProfileCommit struct {
+	Commit
+	RepoName string
+}
+
+type profileEntry struct {
+	refsKey string
+	// byEmail holds the recent commits on the default branch by the
+	// lowercased author email.
+	byEmail map[string][]Commit
+}
+
+// ProfileCache remembers who authored the recent commits of the most
+// recently used repositories, recomputed whenever their refs move.
+type ProfileCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func NewProfileCache() *ProfileCache {
+	return &ProfileCache{cache: lru.New(PROFILE_CACHE_SIZE)}
+}
+
+// Authors returns the latest PROFILE_WALK_DEPTH commits on the default
+// branch of a known repository by lowercased author email.
+func (c *ProfileCache) Authors(ctx context.Context, sc *Smithy, known RepositoryWithName) (map[string][]Commit, error) {
+	repo, err := sc.peekRepo(known)
+	if err != nil {
+		return nil, err
+	}
+	key, err := refsKey(repo.Repository)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	cached, ok := c.cache.Get(known.Name)
+	c.mu.Unlock()
+	if ok && cached.(*profileEntry).refsKey == key {
+		return cached.(*profileEntry).byEmail, nil
+	}
+
+	byEmail := make(map[string][]Commit)
+	if _, head, err := sc.MainBranch(repo); err == nil {
+		cIter, err := repo.Repository.Log(&git.LogOptions{From: *head, Order: git.LogOrderCommitterTime})
+		if err != nil {
+			return nil, err
+		}
+		defer cIter.Close()
+		abbrev := AbbrevLength(repo.Repository)
+		for i := 0; i < PROFILE_WALK_DEPTH; i++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			commit, err := cIter.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			email := strings.ToLower(commit.Author.Email)
+			byEmail[email] = append(byEmail[email], NewCommit(commit, abbrev))
+		}
+	}
+	c.mu.Lock()
+	c.cache.Add(known.Name, &profileEntry{refsKey: key, byEmail: byEmail})
+	c.mu.Unlock()
+	return byEmail, nil
+}
+
+// UserCommits returns the most recent commits authored with one of emails
+// on the default branches of repos.
+func (sc *Smithy) UserCommits(ctx context.Context, repos []RepositoryWithName, emails []string) ([]ProfileCommit, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	wanted := make(map[string]bool)
+	for _, email := range emails {
+		wanted[strings.ToLower(email)] = true
+	}
+	var commits []ProfileCommit
+	for _, known := range repos {
+		byEmail, err := sc.profiles.Authors(ctx, sc, known)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		for email := range wanted {
+			for _, c := range byEmail[email] {
+				commits = append(commits, ProfileCommit{Commit: c, RepoName: known.Name})
+			}
+		}
+	}
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Commit.Commit.Author.When.After(commits[j].Commit.Commit.Author.When)
+	})
+	if len(commits) > PROFILE_COMMITS {
+		commits = commits[:PROFILE_COMMITS]
+	}
+	return commits, nil
+}
+
+// ProfileView shows a user's repositories, the repositories named
+// "<user>/...", their recent commits and their public keys. Users without
+// either that the visitor can see are not found, so that accounts cannot be
+// told apart from names nobody uses.
+func (sc *Smithy) ProfileView(w http.ResponseWriter, r *http.Request) {
+	user := sc.GetParam(r, "user")
+	profile, hasProfile := sc.Config().Profiles[user]
+
+	visible := sc.VisibleRepositories(r)
+	var repos []RepositoryWithName
+	for _, repo := range visible {
+		if strings.HasPrefix(repo.Name, user+"/") {
+			repos = append(repos, repo)
+		}
+	}
+	if !hasProfile && len(repos) == 0 {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("User not found"))
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "profile")
+	defer cancel()
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	commits, err := sc.UserCommits(ctx, visible, profile.Emails)
+	if err != nil {
+		log.Printf("commits of %s: %v", user, err)
+	}
+	if len(repos) == 0 && len(commits) == 0 {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("User not found"))
+		return
+	}
+
+	sc.Render(w, r, "profile", H{
+		"User":    user,
+		"Profile": profile,
+		"Repos":   repos,
+		"Commits": commits,
+	})
+}
+
+// UserKeysView serves a user's SSH keys ("/~user.keys") one per line, as
+// for authorized_keys, or their GPG keys ("/~user.gpg") concatenated.
+func (sc *Smithy) UserKeysView(w http.ResponseWriter, r *http.Request) {
+	profile, exists := sc.Config().Profiles[sc.GetParam(r, "user")]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	keys := profile.SSHKeys
+	if sc.GetParam(r, "kind") == "gpg" {
+		keys = profile.GPGKeys
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, key := range keys {
+		fmt.Fprintln(w, strings.TrimSpace(key))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyAuthConfig trusts a reverse proxy, such as oauth2-proxy or
+// Authelia, to authenticate users and name them in a request header.
+type ProxyAuthConfig struct {
+	// UserHeader names the header holding the user, e.g. Remote-User.
+	UserHeader string
+	// GroupsHeader names the header listing the user's groups, separated
+	// by commas, e.g. Remote-Groups.
+	GroupsHeader string
+	// Trusted lists the networks of the proxies whose headers are believed.
+	// Connections over a unix socket are always trusted.
+	Trusted StringList
+	// Permission is granted to every user the proxy names, so users need
+	// not be known to smithy beforehand. Groups may grant more.
+	Permission string
+	Groups     StringMap
+}
+
+func (c ProxyAuthConfig) Enabled() bool {
+	return c.UserHeader != ""
+}
+
+// trusts reports whether the request comes from a trusted proxy.
+func (c ProxyAuthConfig) trusts(r *http.Request) bool {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return true
+	}
+	return c.trustsIP(net.ParseIP(clientIP(r)))
+}
+
+// trustsIP reports whether ip is in one of the trusted networks.
+func (c ProxyAuthConfig) trustsIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range c.Trusted {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyUser returns the user named by a trusted proxy and the permission
+// they are granted.
+func (sc *Smithy) ProxyUser(r *http.Request) (string, Permission) {
+	c := sc.Config().ProxyAuth
+	if !c.Enabled() || !c.trusts(r) {
+		return "", PermissionNone
+	}
+	user := strings.TrimSpace(r.Header.Get(c.UserHeader))
+	if user == "" {
+		return "", PermissionNone
+	}
+	var groups []string
+	if c.GroupsHeader != "" {
+		for _, group := range strings.Split(r.Header.Get(c.GroupsHeader), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	permission := GroupPermission(c.Groups, groups)
+	if p, err := ParsePermission(c.Permission); err == nil && p > permission {
+		permission = p
+	}
+	return user, permission
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig has separate limits for ordinary pages and for expensive
+// operations: clones and fetches, archive downloads and log views.
+type RateLimitConfig struct {
+	Pages int
+	Heavy int
+}
+
+// RequestLimiter allows each client a number of requests per minute, with
+// bursts of up to a minute worth of requests.
+type RequestLimiter struct {
+	mu        sync.Mutex
+	perMinute float64
+	buckets   map[string]*requestBucket
+	lastSweep time.Time
+}
+
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRequestLimiter returns a limiter for perMinute requests per client, or
+// nil if perMinute is not positive, meaning unlimited.
+func NewRequestLimiter(perMinute int) *RequestLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &RequestLimiter{
+		perMinute: float64(perMinute),
+		buckets:   make(map[string]*requestBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a request from the client's bucket. When the bucket is empty
+// it reports how long until the next request will be allowed.
+func (l *RequestLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	rate := l.perMinute / time.Minute.Seconds()
+
+	// Forget clients whose buckets have filled up again.
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= l.perMinute {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &requestBucket{tokens: l.perMinute, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.perMinute, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// isHeavyRequest reports whether the request is for one of the expensive
+// operations that have their own, usually tighter, rate limit.
+func isHeavyRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/git-upload-pack") ||
+		strings.Contains(r.URL.Path, "/archive/") ||
+		strings.Contains(r.URL.Path, "/compare/") ||
+		r.URL.Path == "/search" ||
+		strings.HasSuffix(r.URL.Path, "/contributors") ||
+		isProfilePath(r.URL.Path) ||
+		strings.Contains(r.URL.Path, "/log/") ||
+		strings.HasSuffix(r.URL.Path, "/log")
+}
+
+// isProfilePath reports whether p is a user's profile page, "/~user".
+func isProfilePath(p string) bool {
+	return strings.HasPrefix(p, "/~") && !strings.Contains(p[2:], "/") &&
+		!strings.HasSuffix(p, ".keys") && !strings.HasSuffix(p, ".gpg")
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RemoteIP returns the client's address. Behind a trusted proxy, or on a
+// unix socket, it is taken from X-Real-IP or X-Forwarded-For instead of the
+// connection.
+func (sc *Smithy) RemoteIP(r *http.Request) string {
+	c := sc.Config().ProxyAuth
+	peer := clientIP(r)
+	if !c.trusts(r) {
+		return peer
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	// Each proxy appends the address it got the request from, so the
+	// client is the last one not added by a trusted proxy.
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		if i == 0 || !c.trustsIP(ip) {
+			return hop
+		}
+	}
+	return peer
+}
+
+// RateLimit answers 429 to clients sending more requests than
+// RateLimit.Pages or, for expensive operations, RateLimit.Heavy allow.
+// Without RateLimit.Heavy expensive operations count against the page limit.
+func (sc *Smithy) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := sc.pageLimiter
+		if isHeavyRequest(r) && sc.heavyLimiter != nil {
+			limiter = sc.heavyLimiter
+		}
+		if limiter == nil || isProbeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limiter.Allow(sc.RemoteIP(r)); !ok {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteIP(t *testing.T) {
+	sc := NewSmithy(SmithyConfig{
+		Root:      t.TempDir(),
+		ProxyAuth: ProxyAuthConfig{Trusted: StringList{"127.0.0.0/8", "10.0.0.0/8"}},
+	})
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		realIP       string
+		forwardedFor string
+		want         string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"untrusted peer sending headers", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy without headers", "127.0.0.1:5000", "", "", "127.0.0.1"},
+		{"X-Real-IP from trusted proxy", "127.0.0.1:5000", "198.51.100.1", "198.51.100.2", "198.51.100.1"},
+		{"invalid X-Real-IP", "127.0.0.1:5000", "not an ip", "198.51.100.2", "198.51.100.2"},
+		{"X-Forwarded-For from trusted proxy", "127.0.0.1:5000", "", "198.51.100.2", "198.51.100.2"},
+		{"spoofed first hop", "127.0.0.1:5000", "", "192.0.2.1, 198.51.100.2", "198.51.100.2"},
+		{"chain of trusted proxies", "127.0.0.1:5000", "", "198.51.100.2, 10.0.0.5", "198.51.100.2"},
+		{"only trusted hops", "127.0.0.1:5000", "", "10.0.0.5", "10.0.0.5"},
+		{"garbage hop", "127.0.0.1:5000", "", "198.51.100.2, bogus", "127.0.0.1"},
+		{"unix socket", "@", "", "198.51.100.2", "198.51.100.2"},
+		{"IPv6 client", "[2001:db8::1]:5000", "", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := sc.RemoteIP(r); got != tt.want {
+			t.Errorf("%s: RemoteIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// IMAGE_TYPES maps the extensions of images shown inline on blob pages to
+// their content types.
+var IMAGE_TYPES = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+// ImageType returns the content type of an image file by its name.
+func ImageType(name string) (string, bool) {
+	contentType, ok := IMAGE_TYPES[strings.ToLower(path.Ext(name))]
+	return contentType, ok
+}
+
+// RawView serves the contents of a file at a ref. Images are served as
+// such, anything else as plain text or bytes, and scripts are blocked so
+// that files in repositories cannot act on behalf of the site.
+func (sc *Smithy) RawView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	refName := sc.GetParam(r, "ref")
+	commit, err := ResolveCommit(repo.Repository, refName)
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	file, err := commit.File(strings.Trim(sc.GetParam(r, "path"), "/"))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, err)
+		return
+	}
+	if sc.NotModified(w, r, refName == commit.Hash.String(), "raw", file.Hash.String()) {
+		return
+	}
+
+	contentType, ok := ImageType(file.Name)
+	if !ok {
+		contentType = "text/plain; charset=utf-8"
+		if binary, err := file.IsBinary(); err != nil || binary {
+			contentType = "application/octet-stream"
+		}
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer reader.Close()
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file.Name)}))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, reader)
+}
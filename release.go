@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ReleaseAsset struct {
+	Name    string
+	Size    int64
+	SHA256  string
+	ModTime time.Time
+}
+
+type Release struct {
+	Tag       TagInfo
+	ShortHash string
+	// Notes is the message of annotated tags, rendered as Markdown.
+	Notes  template.HTML
+	Assets []ReleaseAsset
+}
+
+// ValidAssetName reports whether name can be used as a release asset file
+// name.
+func ValidAssetName(name string) bool {
+	return name != "" &&
+		name == filepath.Base(name) &&
+		!strings.HasPrefix(name, ".") &&
+		!strings.HasSuffix(name, ".sha256")
+}
+
+func (sc *Smithy) ReleaseDir(repoName, tag string) string {
+	return sc.Config().DataPath("releases", repoName, tag)
+}
+
+// ListReleaseAssets returns the assets attached to a tag, sorted by name.
+func (sc *Smithy) ListReleaseAssets(repoName, tag string) ([]ReleaseAsset, error) {
+	dir := sc.ReleaseDir(repoName, tag)
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var assets []ReleaseAsset
+	for _, f := range files {
+		if f.IsDir() || !ValidAssetName(f.Name()) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		sum, _ := os.ReadFile(filepath.Join(dir, f.Name()+".sha256"))
+		digest, _, _ := strings.Cut(string(sum), " ")
+		assets = append(assets, ReleaseAsset{
+			Name:    f.Name(),
+			Size:    info.Size(),
+			SHA256:  digest,
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Name < assets[j].Name })
+	return assets, nil
+}
+
+// SaveReleaseAsset stores an asset for the tag along with a sha256sum(1)
+// compatible checksum file.
+func (sc *Smithy) SaveReleaseAsset(repoName, tag, name string, r io.Reader) (ReleaseAsset, error) {
+	var asset ReleaseAsset
+	if !ValidAssetName(name) {
+		return asset, fmt.Errorf("invalid asset name %q", name)
+	}
+	dir := sc.ReleaseDir(repoName, tag)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return asset, err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return asset, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(tmp, io.TeeReader(r, h))
+	if err != nil {
+		return asset, err
+	}
+	if err := tmp.Close(); err != nil {
+		return asset, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return asset, err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	target := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return asset, err
+	}
+	sum := fmt.Sprintf("%s  %s\n", digest, name)
+	if err := os.WriteFile(target+".sha256", []byte(sum), 0644); err != nil {
+		return asset, err
+	}
+	return ReleaseAsset{Name: name, Size: size, SHA256: digest, ModTime: time.Now()}, nil
+}
+
+func (sc *Smithy) ReleasesView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	tags, err := ListTagInfos(repo.Repository)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	SortTagsByVersion(tags)
+
+	abbrev := AbbrevLength(repo.Repository)
+	var releases []Release
+	for _, tag := range tags {
+		assets, err := sc.ListReleaseAssets(repoName, tag.Name)
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		release := Release{Tag: tag, ShortHash: Abbreviate(tag.Commit.Hash, abbrev), Assets: assets}
+		if tag.Annotated {
+			treeURL := fmt.Sprintf("/%s/tree/%s", repoName, tag.Name)
+			release.Notes = template.HTML(FormatMarkdown(tag.Message, treeURL, ""))
+		}
+		releases = append(releases, release)
+	}
+
+	// With an auth hook, whether uploads are allowed is only known once
+	// they are attempted.
+	_, permission := sc.Identify(r)
+	canUpload := !sc.AuthEnabled() || sc.Config().AuthHook != "" || permission >= PermissionPush
+
+	sc.Render(w, r, "releases", H{
+		"RepoName":  repoName,
+		"Repo":      repo,
+		"Releases":  releases,
+		"CanUpload": canUpload,
+	})
+}
+
+func (sc *Smithy) UploadReleaseAsset(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		sc.Error(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+	if !sc.AuthorizePush(w, r) {
+		return
+	}
+
+	tag := sc.GetParam(r, "ref")
+	if _, err := repo.Repository.Tag(tag); err != nil {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Tag not found: %s", tag))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, sc.Config().MaxAssetSize<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		sc.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sc.Error(w, http.StatusBadRequest, err)
+			return
+		}
+		if part.FormName() != "asset" || part.FileName() == "" {
+			continue
+		}
+		if _, err := sc.SaveReleaseAsset(repoName, tag, part.FileName(), part); err != nil {
+			sc.Error(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	http.Redirect(w, r, fmt.Sprintf("/%s/releases", repoName), http.StatusSeeOther)
+}
+
+func (sc *Smithy) ReleaseAssetView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	tag := sc.GetParam(r, "ref")
+	name := sc.GetParam(r, "file")
+	if _, err := repo.Repository.Tag(tag); err != nil {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Asset not found"))
+		return
+	}
+	if asset, ok := strings.CutSuffix(name, ".sha256"); ok && ValidAssetName(asset) {
+		sc.ServeChecksumFile(w, r, filepath.Join(sc.ReleaseDir(repoName, tag), name))
+		return
+	}
+	if !ValidAssetName(name) {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Asset not found"))
+		return
+	}
+	f, err := os.Open(filepath.Join(sc.ReleaseDir(repoName, tag), name))
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Asset not found"))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// ServeChecksumFile serves a sha256sum(1) compatible checksum file.
+func (sc *Smithy) ServeChecksumFile(w http.ResponseWriter, r *http.Request, path string) {
+	sum, err := os.ReadFile(path)
+	if err != nil {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Checksum not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(sum)
+}
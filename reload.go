@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ReloadConfig parses the command line and config file again and applies
+// the result. Settings fixed at startup, such as the listen addresses, the
+// root and data directories, the sizes of caches and limiters and the
+// package settings ApplyGlobals sets, keep their values until a restart.
+// The SAML certificate and identity provider metadata are read again on the
+// next login.
+func (sc *Smithy) ReloadConfig() error {
+	parsed, err := ParseConfig(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		return err
+	}
+	return sc.UpdateConfig(func(config *SmithyConfig) error {
+		old := *config
+		*config = parsed
+		config.Root = old.Root
+		config.DataDir = old.DataDir
+		config.Port = old.Port
+		config.Listen = old.Listen
+		config.GitDaemonAddr = old.GitDaemonAddr
+		config.TLS = old.TLS
+		config.Server = old.Server
+		config.UploadPackGlobalRate = old.UploadPackGlobalRate
+		config.RateLimit = old.RateLimit
+		config.MaxHeavyOperations = old.MaxHeavyOperations
+		config.ObjectCacheSize = old.ObjectCacheSize
+		config.SharedObjectCache = old.SharedObjectCache
+		config.ReplaceRefs = old.ReplaceRefs
+		config.RenderCacheSize = old.RenderCacheSize
+		config.MaxOpenRepos = old.MaxOpenRepos
+		config.Watch = old.Watch
+		config.SearchIndex = old.SearchIndex
+		config.ReadmeNames = old.ReadmeNames
+		config.HiddenRefs = old.HiddenRefs
+		config.MarkdownExtensions = old.MarkdownExtensions
+		config.HashLength = old.HashLength
+		sc.transfers.Apply(config)
+		sc.saml.Reset()
+		return nil
+	})
+}
+
+// ReloadAll re-reads the configuration and re-scans the repositories root.
+func (sc *Smithy) ReloadAll() error {
+	if err := sc.ReloadConfig(); err != nil {
+		return err
+	}
+	sc.generation.Add(1)
+	sc.pages.Purge()
+	return sc.LoadAllRepositories()
+}
+
+// ReloadOnSignal calls ReloadAll whenever the process receives SIGHUP.
+func (sc *Smithy) ReloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := sc.ReloadAll(); err != nil {
+			log.Printf("reload: %v", err)
+			continue
+		}
+		log.Printf("reloaded configuration and %d repositories", sc.RepoCount())
+	}
+}
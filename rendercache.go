@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/golang/groupcache/lru"
+)
+
+// RenderCache remembers rendered READMEs and highlighted blobs by blob hash,
+// evicting the least recently used ones beyond a total size in bytes.
+type RenderCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+	size  int
+	max   int
+}
+
+func NewRenderCache(maxBytes int) *RenderCache {
+	rc := &RenderCache{cache: lru.New(0), max: maxBytes}
+	rc.cache.OnEvicted = func(key lru.Key, value interface{}) {
+		rc.size -= renderedSize(value)
+	}
+	return rc
+}
+
+func renderedSize(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case HighlightedBlob:
+		return len(v.HTML) + len(v.Reason)
+	}
+	return 0
+}
+
+func (rc *RenderCache) get(key string) (interface{}, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.cache.Get(key)
+}
+
+func (rc *RenderCache) add(key string, value interface{}) {
+	size := renderedSize(value)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if size > rc.max {
+		return
+	}
+	if old, ok := rc.cache.Get(key); ok {
+		rc.size -= renderedSize(old)
+	}
+	rc.cache.Add(key, value)
+	rc.size += size
+	for rc.size > rc.max {
+		rc.cache.RemoveOldest()
+	}
+}
+
+// Readme returns the README file formatted by FormatReadme.
+func (rc *RenderCache) Readme(file *object.File, treeURL string) (string, error) {
+	key := fmt.Sprintf("readme %s %s %s %v", file.Hash, file.Name, treeURL, MARKDOWN_EXTENSIONS)
+	if cached, ok := rc.get(key); ok {
+		return cached.(string), nil
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return "", err
+	}
+	formatted := FormatReadme(file.Name, contents, treeURL)
+	rc.add(key, formatted)
+	return formatted, nil
+}
+
+// Highlight returns the file rendered by RenderSyntaxHighlighting.
+func (rc *RenderCache) Highlight(file *object.File, name string, opts HighlightOptions) (HighlightedBlob, error) {
+	key := fmt.Sprintf("highlight %s %s %+v", file.Hash, name, opts)
+	if cached, ok := rc.get(key); ok {
+		return cached.(HighlightedBlob), nil
+	}
+	contents, truncated, err := blobPrefix(file, opts.MaxBytes)
+	if err != nil {
+		return HighlightedBlob{}, err
+	}
+	highlighted, err := RenderSyntaxHighlighting(name, contents, opts)
+	if err != nil {
+		return highlighted, err
+	}
+	if truncated {
+		highlighted.Truncated = true
+		highlighted.Reason = strings.TrimSpace(fmt.Sprintf("Showing the first %d of %d bytes. %s", len(contents), file.Size, highlighted.Reason))
+	}
+	rc.add(key, highlighted)
+	return highlighted, nil
+}
+
+// blobPrefix returns the contents of file, or of files larger than max
+// bytes, the whole lines within their first max bytes. It reports whether
+// the contents were cut short.
+func blobPrefix(file *object.File, max int64) (string, bool, error) {
+	if max <= 0 || file.Size <= max {
+		contents, err := file.Contents()
+		return contents, false, err
+	}
+	r, err := file.Reader()
+	if err != nil {
+		return "", false, err
+	}
+	defer r.Close()
+	buf := make([]byte, max)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", false, err
+	}
+	buf = buf[:n]
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i+1]
+	}
+	return string(buf), true, nil
+}
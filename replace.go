@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// REPLACE_CHECK_INTERVAL is how often replace refs and grafts are looked
+// at again for changes.
+var REPLACE_CHECK_INTERVAL = time.Second
+
+// ReplaceStorage reads objects the way git does with git-replace and
+// grafts: objects named by refs/replace/<hash> stand in for <hash>, and
+// commits listed in info/grafts get the parents given there. Replaced
+// objects keep the hash they were asked for.
+type ReplaceStorage struct {
+	*filesystem.Storage
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   string
+	replace map[plumbing.Hash]plumbing.Hash
+	grafts  map[plumbing.Hash][]plumbing.Hash
+}
+
+func NewReplaceStorage(s *filesystem.Storage) *ReplaceStorage {
+	return &ReplaceStorage{Storage: s}
+}
+
+type replacedObject struct {
+	plumbing.EncodedObject
+	hash plumbing.Hash
+}
+
+func (o replacedObject) Hash() plumbing.Hash {
+	return o.hash
+}
+
+// replaceStamp changes whenever replace refs or grafts might have.
+func (s *ReplaceStorage) replaceStamp() string {
+	var sb strings.Builder
+	for _, name := range []string{"refs/replace", "packed-refs", "info/grafts"} {
+		if fi, err := s.Filesystem().Stat(name); err == nil {
+			fmt.Fprintf(&sb, "%s %d %d\n", name, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return sb.String()
+}
+
+// load reads the replace refs and grafts of the repository.
+func (s *ReplaceStorage) load() (map[plumbing.Hash]plumbing.Hash, map[plumbing.Hash][]plumbing.Hash) {
+	replace := make(map[plumbing.Hash]plumbing.Hash)
+	if refs, err := s.Storage.IterReferences(); err == nil {
+		refs.ForEach(func(ref *plumbing.Reference) error {
+			name, ok := strings.CutPrefix(ref.Name().String(), "refs/replace/")
+			if ok && ref.Type() == plumbing.HashReference && plumbing.IsHash(name) {
+				replace[plumbing.NewHash(name)] = ref.Hash()
+			}
+			return nil
+		})
+	}
+
+	grafts := make(map[plumbing.Hash][]plumbing.Hash)
+	if f, err := s.Filesystem().Open("info/grafts"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || !plumbing.IsHash(fields[0]) {
+				continue
+			}
+			parents := []plumbing.Hash{}
+			for _, parent := range fields[1:] {
+				if plumbing.IsHash(parent) {
+					parents = append(parents, plumbing.NewHash(parent))
+				}
+			}
+			grafts[plumbing.NewHash(fields[0])] = parents
+		}
+	}
+	return replace, grafts
+}
+
+// lookup returns what stands in for h and the parents grafted onto it.
+func (s *ReplaceStorage) lookup(h plumbing.Hash) (plumbing.Hash, []plumbing.Hash, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checked) >= REPLACE_CHECK_INTERVAL {
+		s.checked = time.Now()
+		if stamp := s.replaceStamp(); stamp != s.stamp || s.replace == nil {
+			s.stamp = stamp
+			s.replace, s.grafts = s.load()
+		}
+	}
+	with, replaced := s.replace[h]
+	parents, grafted := s.grafts[h]
+	return with, parents, replaced, grafted
+}
+
+// EncodedObject returns the object h, or what replaces it.
+func (s *ReplaceStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	with, parents, replaced, grafted := s.lookup(h)
+	if !replaced && !grafted {
+		return s.Storage.EncodedObject(t, h)
+	}
+	if !replaced {
+		with = h
+	}
+	obj, err := s.Storage.EncodedObject(t, with)
+	if err != nil {
+		return nil, err
+	}
+	if grafted && obj.Type() == plumbing.CommitObject {
+		commit := &object.Commit{}
+		if err := commit.Decode(obj); err != nil {
+			return nil, err
+		}
+		commit.ParentHashes = parents
+		mem := &plumbing.MemoryObject{}
+		if err := commit.Encode(mem); err != nil {
+			return nil, err
+		}
+		obj = mem
+	}
+	return replacedObject{EncodedObject: obj, hash: h}, nil
+}
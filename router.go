@@ -25,6 +25,10 @@ type Route struct {
 
 type Router struct {
 	routes []Route
+	// HasRepo reports whether a "repo" parameter names a repository. As
+	// repository names may contain slashes, a route whose match names an
+	// unknown repository gives way to a later route naming a known one.
+	HasRepo func(name string) bool
 }
 
 func NewRouter(routes []Route) *Router {
@@ -37,6 +41,8 @@ func newContextWithParams(ctx context.Context, params map[string]string) context
 
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println(r.Method, r.URL.Path)
+	var fallback http.HandlerFunc
+	var fallbackParams map[string]string
 	for _, route := range router.routes {
 		re := route.pattern
 		match := re.FindStringSubmatch(r.URL.Path)
@@ -48,11 +54,21 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					params[name] = match[i]
 				}
 			}
+			if repo, ok := params["repo"]; ok && router.HasRepo != nil && !router.HasRepo(repo) {
+				if fallback == nil {
+					fallback, fallbackParams = route.handler, params
+				}
+				continue
+			}
 			// Call the handler with the extracted parameter values
 			route.handler(w, r.WithContext(newContextWithParams(r.Context(), params)))
 			return
 		}
 	}
+	if fallback != nil {
+		fallback(w, r.WithContext(newContextWithParams(r.Context(), fallbackParams)))
+		return
+	}
 	// No matching route found
 	http.NotFound(w, r)
 }
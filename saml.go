@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+var SAML_STATE_COOKIE = "smithy_saml"
+
+type SAMLConfig struct {
+	// IDPMetadata is the URL or path of the identity provider's metadata.
+	// SAML needs ExternalURL, which names the service provider.
+	IDPMetadata string
+	// CertFile and KeyFile identify smithy to the identity provider.
+	CertFile string
+	KeyFile  string
+	// UserAttribute names the attribute holding the user name. The NameID
+	// of the assertion is used when it is empty or missing.
+	UserAttribute string
+	// GroupsAttribute names the attribute listing the user's groups.
+	GroupsAttribute string
+	// Groups maps group names to permissions. Users in none of them may
+	// only read.
+	Groups StringMap
+}
+
+func (c SAMLConfig) Enabled() bool {
+	return c.IDPMetadata != ""
+}
+
+// samlIdentity holds what the service provider needs from disk and from
+// the identity provider, loaded on first use and again after a reload.
+type samlIdentity struct {
+	mu          sync.Mutex
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+	idp         *saml.EntityDescriptor
+}
+
+// Reset forgets the loaded identity so that the next login reads the
+// certificate and metadata again.
+func (s *samlIdentity) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key, s.certificate, s.idp = nil, nil, nil
+}
+
+func (s *samlIdentity) load(ctx context.Context, config SAMLConfig) (samlIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key == nil {
+		pair, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return samlIdentity{}, fmt.Errorf("SAML certificate: %v", err)
+		}
+		key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return samlIdentity{}, fmt.Errorf("SAML certificate: %s is not an RSA key", config.KeyFile)
+		}
+		certificate, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return samlIdentity{}, fmt.Errorf("SAML certificate: %v", err)
+		}
+		s.key, s.certificate = key, certificate
+	}
+	if s.idp == nil {
+		var idp *saml.EntityDescriptor
+		var err error
+		if u, parseErr := url.Parse(config.IDPMetadata); parseErr == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			idp, err = samlsp.FetchMetadata(ctx, oidcClient, *u)
+		} else {
+			var data []byte
+			if data, err = os.ReadFile(config.IDPMetadata); err == nil {
+				idp, err = samlsp.ParseMetadata(data)
+			}
+		}
+		if err != nil {
+			return samlIdentity{}, fmt.Errorf("SAML identity provider metadata: %v", err)
+		}
+		s.idp = idp
+	}
+	return samlIdentity{key: s.key, certificate: s.certificate, idp: s.idp}, nil
+}
+
+// samlServiceProvider describes smithy at its configured external URL. It
+// is never taken from the request, whose Host header the client picks.
+func (sc *Smithy) samlServiceProvider(r *http.Request) (*saml.ServiceProvider, error) {
+	identity, err := sc.saml.load(r.Context(), sc.Config().SAML)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(sc.Config().ExternalURL, "/")
+	metadataURL, err := url.Parse(base + "/login/saml/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(base + "/login/saml/acs")
+	if err != nil {
+		return nil, err
+	}
+	return &saml.ServiceProvider{
+		EntityID:    metadataURL.String(),
+		Key:         identity.key,
+		Certificate: identity.certificate,
+		MetadataURL: *metadataURL,
+		AcsURL:      *acsURL,
+		IDPMetadata: identity.idp,
+		HTTPClient:  oidcClient,
+	}, nil
+}
+
+// SAMLMetadataView serves the service provider metadata to register with
+// the identity provider.
+func (sc *Smithy) SAMLMetadataView(w http.ResponseWriter, r *http.Request) {
+	if !sc.Config().SAML.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	sp, err := sc.samlServiceProvider(r)
+	if err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+	buf, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(buf)
+}
+
+// SAMLLoginView sends the browser to the identity provider. The request ID,
+// and where to go after logging in, are kept in a signed cookie.
+func (sc *Smithy) SAMLLoginView(w http.ResponseWriter, r *http.Request) {
+	if !sc.Config().SAML.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	sp, err := sc.samlServiceProvider(r)
+	if err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+	req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	redirect, err := req.Redirect("", sp)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	payload := req.ID + "|" + localRedirect(r.FormValue("next"))
+	cookie := &http.Cookie{
+		Name:     SAML_STATE_COOKIE,
+		Value:    url.QueryEscape(payload + "|" + sc.signSession(payload)),
+		Path:     "/login/saml",
+		MaxAge:   int(OIDC_STATE_LIFETIME.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(sc.BaseURL(r), "https:"),
+	}
+	// The identity provider posts the response back cross-site, which
+	// browsers only allow for secure cookies.
+	if cookie.Secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// samlState returns the request ID and next location saved by
+// SAMLLoginView.
+func (sc *Smithy) samlState(r *http.Request) (string, string, bool) {
+	cookie, err := r.Cookie(SAML_STATE_COOKIE)
+	if err != nil {
+		return "", "", false
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return "", "", false
+	}
+	i := strings.LastIndex(value, "|")
+	if i < 0 || !sc.checkSession(value[:i], value[i+1:]) {
+		return "", "", false
+	}
+	id, next, _ := strings.Cut(value[:i], "|")
+	return id, next, true
+}
+
+// samlAttribute returns the values of the named attribute, matched against
+// both its name and friendly name.
+func samlAttribute(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if name == "" || (attr.Name != name && attr.FriendlyName != name) {
+				continue
+			}
+			for _, value := range attr.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+	return values
+}
+
+// SAMLACSView receives the identity provider's response and logs the user
+// in with the permission granted by their groups.
+func (sc *Smithy) SAMLACSView(w http.ResponseWriter, r *http.Request) {
+	if !sc.Config().SAML.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	id, next, ok := sc.samlState(r)
+	if !ok || id == "" {
+		sc.Error(w, http.StatusBadRequest, fmt.Errorf("Invalid login state, please try again"))
+		return
+	}
+	sp, err := sc.samlServiceProvider(r)
+	if err != nil {
+		sc.Error(w, http.StatusBadGateway, err)
+		return
+	}
+	assertion, err := sp.ParseResponse(r, []string{id})
+	if err != nil {
+		if invalid, ok := err.(*saml.InvalidResponseError); ok {
+			log.Printf("SAML response: %v", invalid.PrivateErr)
+		}
+		sc.Error(w, http.StatusUnauthorized, fmt.Errorf("Login failed: %v", err))
+		return
+	}
+
+	var user string
+	if values := samlAttribute(assertion, sc.Config().SAML.UserAttribute); sc.Config().SAML.UserAttribute != "" && len(values) > 0 {
+		user = values[0]
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		user = assertion.Subject.NameID.Value
+	}
+	if user == "" {
+		sc.Error(w, http.StatusBadGateway, fmt.Errorf("The identity provider did not name the user"))
+		return
+	}
+	permission := GroupPermission(sc.Config().SAML.Groups, samlAttribute(assertion, sc.Config().SAML.GroupsAttribute))
+	log.Printf("SAML login of %s with permission %d", user, permission)
+
+	http.SetCookie(w, &http.Cookie{Name: SAML_STATE_COOKIE, Path: "/login/saml", MaxAge: -1})
+	http.SetCookie(w, sc.NewSessionCookie(r, SESSION_SAML, permission, user))
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// SEARCH_MAX_FILE_SIZE skips larger files, which are rarely source code.
+	SEARCH_MAX_FILE_SIZE int64 = 1 << 20
+	// SEARCH_MAX_RESULTS stops a search after that many matching lines.
+	SEARCH_MAX_RESULTS = 200
+)
+
+// SearchMatch is a line matching a code search, split around the first
+// match so that it can be highlighted.
+type SearchMatch struct {
+	Repo   string
+	Ref    string
+	Path   string
+	Line   int
+	Before string
+	Match  string
+	After  string
+}
+
+// SearchTree greps the text files of a commit's tree for query, ignoring
+// case, and returns at most max matching lines.
+func SearchTree(ctx context.Context, commit *object.Commit, query string, max int) ([]SearchMatch, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	needle := strings.ToLower(query)
+	var matches []SearchMatch
+	files := tree.Files()
+	defer files.Close()
+	for len(matches) < max {
+		if err := ctx.Err(); err != nil {
+			return matches, err
+		}
+		file, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return matches, err
+		}
+		if file.Size > SEARCH_MAX_FILE_SIZE {
+			continue
+		}
+		if binary, err := file.IsBinary(); err != nil || binary {
+			continue
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return matches, err
+		}
+		matches = append(matches, searchLines(reader, file.Name, needle, max-len(matches))...)
+		reader.Close()
+	}
+	return matches, nil
+}
+
+// searchLines returns at most max lines of a file containing needle, which
+// is lowercase.
+func searchLines(reader io.Reader, name, needle string, max int) []SearchMatch {
+	var matches []SearchMatch
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, int(SEARCH_MAX_FILE_SIZE))
+	for n := 1; scanner.Scan() && len(matches) < max; n++ {
+		line := scanner.Text()
+		i := strings.Index(strings.ToLower(line), needle)
+		if i < 0 {
+			continue
+		}
+		match := SearchMatch{Path: name, Line: n, Before: line}
+		// Lowercasing changes the length of a few characters; the
+		// offsets only apply to the line when it did not.
+		if len(line) == len(strings.ToLower(line)) {
+			match.Before, match.Match, match.After = line[:i], line[i:i+len(needle)], line[i+len(needle):]
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// SearchIndexed searches the files of a repository the index says may
+// contain query.
+func SearchIndexed(ctx context.Context, r *git.Repository, ri *RepoIndex, query string, max int) ([]SearchMatch, error) {
+	needle := strings.ToLower(query)
+	var matches []SearchMatch
+	for _, p := range ri.Candidates(query) {
+		if len(matches) >= max {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return matches, err
+		}
+		blob, err := r.BlobObject(ri.Files[p].Blob)
+		if err != nil {
+			return matches, err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return matches, err
+		}
+		matches = append(matches, searchLines(reader, p, needle, max-len(matches))...)
+		reader.Close()
+	}
+	return matches, nil
+}
+
+// searchRepo searches the indexed commit of a repository, or its default
+// branch when it has no index.
+func (sc *Smithy) searchRepo(ctx context.Context, repo RepositoryWithName, query string, max int) ([]SearchMatch, error) {
+	var found []SearchMatch
+	var ref string
+	var err error
+	if ri := sc.index.Get(repo.Name); ri != nil {
+		// Link to the indexed commit, which the lines were found in.
+		ref = ri.Head.String()
+		found, err = SearchIndexed(ctx, repo.Repository, ri, query, max)
+	} else {
+		var revision *plumbing.Hash
+		ref, revision, err = sc.MainBranch(repo)
+		if err != nil {
+			return nil, nil
+		}
+		var commit *object.Commit
+		if commit, err = repo.Repository.CommitObject(*revision); err != nil {
+			return nil, err
+		}
+		found, err = SearchTree(ctx, commit, query, max)
+	}
+	for i := range found {
+		found[i].Repo, found[i].Ref = repo.Name, ref
+	}
+	return found, err
+}
+
+// SearchView searches the default branches of all repositories the request
+// may see for ?q=.
+func (sc *Smithy) SearchView(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		sc.Render(w, r, "search", H{})
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "search")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	var matches []SearchMatch
+	var commits []CommitMatch
+	for _, known := range sc.VisibleRepositories(r) {
+		if len(matches) >= SEARCH_MAX_RESULTS {
+			break
+		}
+		repo, err := sc.openRepo(known)
+		if err != nil {
+			log.Printf("search %s: %v", known.Name, err)
+			continue
+		}
+		if ri := sc.index.Get(repo.Name); ri != nil {
+			for _, c := range ri.SearchCommits(query, SEARCH_MAX_RESULTS-len(commits)) {
+				commits = append(commits, CommitMatch{Repo: repo.Name, indexedCommit: c})
+			}
+		}
+		found, err := sc.searchRepo(ctx, repo, query, SEARCH_MAX_RESULTS-len(matches))
+		matches = append(matches, found...)
+		if err != nil {
+			if ctx.Err() != nil {
+				sc.Error(w, http.StatusServiceUnavailable, fmt.Errorf("Search timed out, try a more specific query"))
+				return
+			}
+			log.Printf("search %s: %v", repo.Name, err)
+		}
+	}
+
+	sc.Render(w, r, "search", H{
+		"Query":     query,
+		"Matches":   matches,
+		"Commits":   commits,
+		"Truncated": len(matches) >= SEARCH_MAX_RESULTS,
+	})
+}
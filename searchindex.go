@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+var (
+	// SEARCH_INDEX_COMMITS is how many commit messages are indexed per
+	// repository.
+	SEARCH_INDEX_COMMITS = 10000
+	// SEARCH_INDEX_TIMEOUT bounds indexing a repository.
+	SEARCH_INDEX_TIMEOUT = 10 * time.Minute
+)
+
+// trigram is three bytes of lowercased text. Files are indexed by the
+// trigrams they contain, so that only files containing all trigrams of a
+// query need to be searched.
+type trigram uint32
+
+func addTrigrams(set map[trigram]bool, text string) {
+	text = strings.ToLower(text)
+	for i := 0; i+3 <= len(text); i++ {
+		set[trigram(text[i])<<16|trigram(text[i+1])<<8|trigram(text[i+2])] = true
+	}
+}
+
+func sortedTrigrams(set map[trigram]bool) []trigram {
+	trigrams := make([]trigram, 0, len(set))
+	for t := range set {
+		trigrams = append(trigrams, t)
+	}
+	sort.Slice(trigrams, func(i, j int) bool { return trigrams[i] < trigrams[j] })
+	return trigrams
+}
+
+type indexedFile struct {
+	Blob plumbing.Hash
+	// Trigrams are sorted.
+	Trigrams []trigram
+}
+
+func (f *indexedFile) contains(query []trigram) bool {
+	for _, t := range query {
+		i := sort.Search(len(f.Trigrams), func(i int) bool { return f.Trigrams[i] >= t })
+		if i == len(f.Trigrams) || f.Trigrams[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+type indexedCommit struct {
+	Hash    plumbing.Hash
+	Message string
+	Author  string
+	When    time.Time
+}
+
+// RepoIndex is the search index of a repository's default branch. It is
+// not modified once published; updates replace it.
+type RepoIndex struct {
+	Head  plumbing.Hash
+	Files map[string]*indexedFile
+	// Commits are newest first.
+	Commits []*indexedCommit
+}
+
+// Candidates returns the paths of the files that may contain query, sorted.
+func (ri *RepoIndex) Candidates(query string) []string {
+	set := make(map[trigram]bool)
+	addTrigrams(set, query)
+	trigrams := sortedTrigrams(set)
+	var paths []string
+	for p, f := range ri.Files {
+		if f.contains(trigrams) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// CommitMatch is a commit whose message matches a search.
+type CommitMatch struct {
+	Repo string
+	*indexedCommit
+}
+
+// Subject returns the first line of the commit message.
+func (c CommitMatch) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// SearchCommits returns at most max commits whose message contains query,
+// ignoring case.
+func (ri *RepoIndex) SearchCommits(query string, max int) []*indexedCommit {
+	needle := strings.ToLower(query)
+	var commits []*indexedCommit
+	for _, c := range ri.Commits {
+		if len(commits) >= max {
+			break
+		}
+		if strings.Contains(strings.ToLower(c.Message), needle) {
+			commits = append(commits, c)
+		}
+	}
+	return commits
+}
+
+// SearchIndex keeps the search indexes of all repositories, each saved to
+// its own file in dir.
+type SearchIndex struct {
+	mu    sync.Mutex
+	dir   string
+	repos map[string]*RepoIndex
+	// updating serializes updates.
+	updating sync.Mutex
+}
+
+func NewSearchIndex(dir string) *SearchIndex {
+	return &SearchIndex{dir: dir, repos: make(map[string]*RepoIndex)}
+}
+
+func (si *SearchIndex) path(repoName string) string {
+	return filepath.Join(si.dir, url.PathEscape(repoName)+".gob")
+}
+
+// Get returns the index of a repository, or nil if it was never indexed or
+// the index is disabled.
+func (si *SearchIndex) Get(repoName string) *RepoIndex {
+	if si == nil {
+		return nil
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	if ri, ok := si.repos[repoName]; ok {
+		return ri
+	}
+	var ri *RepoIndex
+	f, err := os.Open(si.path(repoName))
+	if err == nil {
+		ri = &RepoIndex{}
+		err = gob.NewDecoder(bufio.NewReader(f)).Decode(ri)
+		f.Close()
+	}
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("search index of %s: %v", repoName, err)
+		}
+		ri = nil
+	}
+	si.repos[repoName] = ri
+	return ri
+}
+
+func (si *SearchIndex) put(repoName string, ri *RepoIndex) error {
+	si.mu.Lock()
+	si.repos[repoName] = ri
+	si.mu.Unlock()
+	if err := os.MkdirAll(si.dir, 0755); err != nil {
+		return err
+	}
+	tmp := si.path(repoName) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = gob.NewEncoder(w).Encode(ri)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, si.path(repoName))
+}
+
+// Rename moves the index of a repository to its new name.
+func (si *SearchIndex) Rename(old, new string) error {
+	if si == nil {
+		return nil
+	}
+	si.updating.Lock()
+	defer si.updating.Unlock()
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	delete(si.repos, old)
+	delete(si.repos, new)
+	err := os.Rename(si.path(old), si.path(new))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Update brings the index of a repository up to the commit head, indexing
+// only the files and commits added since the indexed one.
+func (si *SearchIndex) Update(ctx context.Context, repo RepositoryWithName, head plumbing.Hash) error {
+	si.updating.Lock()
+	defer si.updating.Unlock()
+	old := si.Get(repo.Name)
+	if old != nil && old.Head == head {
+		return nil
+	}
+	commit, err := repo.Repository.CommitObject(head)
+	if err != nil {
+		return err
+	}
+	ri := &RepoIndex{Head: head, Files: make(map[string]*indexedFile)}
+
+	var oldCommit *object.Commit
+	if old != nil {
+		oldCommit, _ = repo.Repository.CommitObject(old.Head)
+	}
+	if oldCommit != nil {
+		for p, f := range old.Files {
+			ri.Files[p] = f
+		}
+		err = indexChanges(ctx, repo.Repository, oldCommit, commit, ri.Files)
+	} else {
+		err = indexTree(ctx, repo.Repository, commit, ri.Files)
+	}
+	if err != nil {
+		return err
+	}
+
+	var oldCommits []*indexedCommit
+	if old != nil {
+		oldCommits = old.Commits
+	}
+	if ri.Commits, err = indexCommits(ctx, commit, old, oldCommits); err != nil {
+		return err
+	}
+	return si.put(repo.Name, ri)
+}
+
+// indexBlob returns the index entry of a blob, or nil for binary and large
+// files, which are not searched.
+func indexBlob(r *git.Repository, hash plumbing.Hash) (*indexedFile, error) {
+	blob, err := r.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if blob.Size > SEARCH_MAX_FILE_SIZE {
+		return nil, nil
+	}
+	file := object.NewFile("", 0, blob)
+	if binary, err := file.IsBinary(); err != nil || binary {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[trigram]bool)
+	addTrigrams(set, contents)
+	return &indexedFile{Blob: hash, Trigrams: sortedTrigrams(set)}, nil
+}
+
+func indexTree(ctx context.Context, r *git.Repository, commit *object.Commit, files map[string]*indexedFile) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.Mode.IsFile() {
+			continue
+		}
+		f, err := indexBlob(r, entry.Hash)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			files[name] = f
+		}
+	}
+}
+
+func indexChanges(ctx context.Context, r *git.Repository, from, to *object.Commit, files map[string]*indexedFile) error {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return err
+	}
+	changes, err := object.DiffTreeContext(ctx, fromTree, toTree)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return err
+		}
+		if action != merkletrie.Insert {
+			delete(files, change.From.Name)
+		}
+		if action == merkletrie.Delete || !change.To.TreeEntry.Mode.IsFile() {
+			continue
+		}
+		f, err := indexBlob(r, change.To.TreeEntry.Hash)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			files[change.To.Name] = f
+		}
+	}
+	return nil
+}
+
+// indexCommits lists the messages of the newest SEARCH_INDEX_COMMITS commits
+// up to head, reusing those of the previous index when head descends from
+// its head.
+func indexCommits(ctx context.Context, head *object.Commit, old *RepoIndex, oldCommits []*indexedCommit) ([]*indexedCommit, error) {
+	if old != nil {
+		// Commits merged in from a side branch may be older than the
+		// previous head, so the walk skips what was indexed rather than
+		// stopping at the previous head.
+		seen := map[plumbing.Hash]bool{old.Head: true}
+		for _, c := range oldCommits {
+			seen[c.Hash] = true
+		}
+		commits, descends, err := walkIndexCommits(ctx, head, seen, old.Head)
+		if err != nil {
+			return nil, err
+		}
+		if descends {
+			commits = append(commits, oldCommits...)
+			sort.SliceStable(commits, func(i, j int) bool {
+				return commits[i].When.After(commits[j].When)
+			})
+			if len(commits) > SEARCH_INDEX_COMMITS {
+				commits = commits[:SEARCH_INDEX_COMMITS]
+			}
+			return commits, nil
+		}
+	}
+	commits, _, err := walkIndexCommits(ctx, head, nil, plumbing.ZeroHash)
+	return commits, err
+}
+
+// walkIndexCommits lists up to SEARCH_INDEX_COMMITS commits reachable from
+// head without passing through seen, and reports whether oldHead is head
+// or a parent of one of them.
+func walkIndexCommits(ctx context.Context, head *object.Commit, seen map[plumbing.Hash]bool, oldHead plumbing.Hash) ([]*indexedCommit, bool, error) {
+	var commits []*indexedCommit
+	descends := head.Hash == oldHead
+	cIter := object.NewCommitIterCTime(head, seen, nil)
+	defer cIter.Close()
+	for len(commits) < SEARCH_INDEX_COMMITS {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		c, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		for _, parent := range c.ParentHashes {
+			if parent == oldHead {
+				descends = true
+			}
+		}
+		commits = append(commits, &indexedCommit{
+			Hash:    c.Hash,
+			Message: c.Message,
+			Author:  c.Author.Name,
+			When:    c.Author.When,
+		})
+	}
+	return commits, descends, nil
+}
+
+// UpdateSearchIndex indexes the default branch of a repository when the
+// search index is enabled.
+func (sc *Smithy) UpdateSearchIndex(repo RepositoryWithName) {
+	if sc.index == nil {
+		return
+	}
+	_, head, err := sc.MainBranch(repo)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), SEARCH_INDEX_TIMEOUT)
+	defer cancel()
+	start := time.Now()
+	if err := sc.index.Update(ctx, repo, *head); err != nil {
+		log.Printf("indexing %s: %v", repo.Name, err)
+		return
+	}
+	if d := time.Since(start); d > time.Second {
+		log.Printf("indexed %s in %v", repo.Name, d.Round(time.Millisecond))
+	}
+}
+
+// IndexAllRepositories brings the search index of every repository up to
+// date, one repository at a time.
+func (sc *Smithy) IndexAllRepositories() {
+	for _, known := range sc.GetRepositories() {
+		repo, err := sc.openRepo(known)
+		if err != nil {
+			log.Printf("indexing %s: %v", known.Name, err)
+			continue
+		}
+		sc.UpdateSearchIndex(repo)
+	}
+}
@@ -2,23 +2,35 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 )
 
 var (
-	offset        = 5
-	PAGE_SIZE int = 500
+	offset             = 5
+	PAGE_SIZE      int = 500
+	TREE_PAGE_SIZE int = 1000
+	// PICKAXE_LIMIT is how many commits touching the path are searched.
+	PICKAXE_LIMIT int = 1000
+	// LOG_SEARCH_LIMIT is how many commits are searched by message and
+	// author.
+	LOG_SEARCH_LIMIT int = 10000
 )
 
 //go:embed templates
@@ -27,6 +39,8 @@ var templatefiles embed.FS
 type GitCommand struct {
 	procInput *bytes.Reader
 	args      []string
+	// throttle applies the upload-pack bandwidth limits to the output.
+	throttle bool
 }
 
 type H = map[string]interface{}
@@ -63,72 +77,214 @@ func (sc *Smithy) GetParam(r *http.Request, name string) (out string) {
 	return r.Context().Value(ParamsKey).(map[string]string)[name]
 }
 
-func (sc *Smithy) Render(w http.ResponseWriter, name string, data H) {
+// Render executes a template with data, which also gets the CurrentUser and
+// Locale of the request and, on repository pages, the repository's Notice.
+// r may be nil for anonymous pages.
+func (sc *Smithy) Render(w http.ResponseWriter, r *http.Request, name string, data H) {
+	data["CurrentUser"] = sc.CurrentUser(r)
+	data["Locale"] = sc.Locale(r)
+	if name, ok := data["RepoName"].(string); ok {
+		if repo, exists := sc.FindRepo(name); exists {
+			if notice := sc.RepoSettings(repo).Notice; notice != "" {
+				data["Notice"] = template.HTML(FormatMarkdown(notice, "", ""))
+			}
+		}
+	}
+	config := sc.Config()
+	site := H{
+		"Title":       config.Title,
+		"Description": config.Description,
+		"Host":        config.Host,
+		"URL":         config.CloneURL(),
+		"Banner":      sc.banner.HTML(config.Announcement),
+		"Logo":        DEFAULT_LOGO,
+	}
+	if _, ok := sc.StaticFile("logo.svg"); ok {
+		site["Logo"] = "/static/logo.svg"
+	}
+	if _, ok := sc.StaticFile("custom.css"); ok {
+		site["CSS"] = "/static/custom.css"
+	}
+	data["Site"] = site
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	sc.template.ExecuteTemplate(w, name+".html", data)
+	w.Header().Add("Vary", "Accept-Language")
+	fw := NewFlushWriter(w, FLUSH_SIZE)
+	sc.template.ExecuteTemplate(fw, name+".html", data)
+}
+
+// GetPage returns the 1-based page number from the query string.
+func GetPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
 }
 
 func (sc *Smithy) Error(w http.ResponseWriter, code int, err error) {
+	if errors.Is(err, context.Canceled) {
+		log.Printf("request canceled: %v", err)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		sc.Render(w, nil, "timeout", H{})
+		return
+	}
 	w.WriteHeader(code)
-	sc.Render(w, "error", H{
+	sc.Render(w, nil, "error", H{
 		"Error": err.Error(),
 	})
 }
 
+func (sc *Smithy) JSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func (sc *Smithy) JSONError(w http.ResponseWriter, code int, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		code = http.StatusServiceUnavailable
+	}
+	sc.JSON(w, code, H{"error": err.Error()})
+}
+
+// RedirectAliases permanently redirects requests addressing a repository by
+// one of its aliases to the same location under the canonical name.
+func (sc *Smithy) RedirectAliases(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, rest := splitRepoPath(strings.TrimPrefix(r.URL.Path, "/"), func(name string) bool {
+			_, ok := sc.ResolveAlias(name)
+			return ok
+		})
+		target, ok := sc.ResolveAlias(name)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		location := "/" + target
+		if rest != "" || strings.HasSuffix(r.URL.Path, "/") {
+			location += "/" + rest
+		}
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, location, code)
+	})
+}
+
+// OperationContext derives a context from the request that is cancelled when
+// the client goes away or the time limit configured for op expires.
+func (sc *Smithy) OperationContext(r *http.Request, op string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), sc.Config().Timeout(op))
+}
+
 func (sc *Smithy) Reload(w http.ResponseWriter, r *http.Request) {
-	sc.LoadAllRepositories()
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if err := sc.ReloadAll(); err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
 	fmt.Fprintf(w, "done")
 }
 
 func (sc *Smithy) IndexView(w http.ResponseWriter, r *http.Request) {
-	repos := sc.GetRepositories()
+	settings := make(map[string]RepoSettings)
+	var repos []RepositoryWithName
+	for _, repo := range sc.VisibleRepositories(r) {
+		settings[repo.Name] = sc.RepoSettings(repo)
+		if !settings[repo.Name].Hidden {
+			repos = append(repos, repo)
+		}
+	}
+	user := sc.CurrentUser(r)
+	starredOnly := user != "" && r.URL.Query().Get("starred") == "1"
+	if starredOnly {
+		var starred []RepositoryWithName
+		for _, repo := range repos {
+			if sc.lists.Contains(ListStarred, user, repo.Name) {
+				starred = append(starred, repo)
+			}
+		}
+		repos = starred
+	}
 	// commits, _ := repo.CommitObjects()
 	// lastCommit, _ := commits.Next()
-	sc.Render(w, "index", H{
-		"Repos": repos,
+	groups := GroupByNamespace(repos)
+	var namespaces []string
+	for _, group := range groups {
+		if group.Namespace != "" {
+			namespaces = append(namespaces, group.Namespace)
+		}
+	}
+	query := r.URL.Query()
+	collapsed := strings.FieldsFunc(query.Get("collapsed"), func(c rune) bool { return c == ',' })
+	for i, group := range groups {
+		if group.Namespace == "" {
+			continue
+		}
+		groups[i].Collapsed = containsString(collapsed, group.Namespace)
+		toggled := []string{group.Namespace}
+		for _, ns := range collapsed {
+			if ns != group.Namespace {
+				toggled = append(toggled, ns)
+			}
+		}
+		if groups[i].Collapsed {
+			toggled = toggled[1:]
+		}
+		groups[i].Toggle = collapsedURL(query, toggled)
+	}
+	sc.Render(w, r, "index", H{
+		"Repos":       repos,
+		"Groups":      groups,
+		"Settings":    settings,
+		"Starred":     starredOnly,
+		"CollapseAll": collapsedURL(query, namespaces),
+		"ExpandAll":   collapsedURL(query, nil),
 	})
 }
 
-func (sc *Smithy) NewProject(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		sc.Render(w, "new", H{})
-		return
+// collapsedURL returns the query of the index with the groups of the given
+// namespaces collapsed.
+func collapsedURL(query url.Values, namespaces []string) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
 	}
-	r.ParseForm()
-	repoName := r.FormValue("name")
-	repoPath := filepath.Join(sc.Root, repoName)
-	_, err := git.PlainInit(repoPath, true)
-	if err != nil {
-		sc.Error(w, http.StatusInternalServerError, err)
+	sort.Strings(namespaces)
+	if len(namespaces) > 0 {
+		q.Set("collapsed", strings.Join(namespaces, ","))
+	} else {
+		q.Del("collapsed")
 	}
-	fmt.Fprint(w, repoName)
+	return "?" + q.Encode()
 }
 
-func (sc *Smithy) ImportProject(w http.ResponseWriter, r *http.Request) {
+func (sc *Smithy) NewProject(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
 	if r.Method == http.MethodGet {
-		sc.Render(w, "import", H{})
+		sc.Render(w, r, "new", H{})
 		return
 	}
 	r.ParseForm()
-	name := r.FormValue("name")
-	bare := r.FormValue("bare")
-	address := r.FormValue("git")
-	repoPath := filepath.Join(sc.Root, name)
-	isBare := bare == "on"
-	repo, err := git.PlainClone(repoPath, isBare, &git.CloneOptions{
-		URL: address,
-	})
+	repo, err := sc.CreateRepository(r.FormValue("name"))
 	if err != nil {
-		sc.Error(w, http.StatusInternalServerError, err)
+		sc.Error(w, http.StatusBadRequest, err)
 		return
 	}
-	rwn := RepositoryWithName{
-		Name:       name,
-		Repository: repo,
-		Path:       repoPath,
-	}
-	sc.AddRepository(rwn)
-	sc.Reload(w, r)
+	fmt.Fprint(w, repo.Name)
 }
 
 func (sc *Smithy) RepoView(w http.ResponseWriter, r *http.Request) {
@@ -151,7 +307,7 @@ func (sc *Smithy) RepoView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	main, revision, err := FindMainBranch(repo.Repository)
+	main, revision, err := sc.MainBranch(repo)
 	if err != nil {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
@@ -163,25 +319,48 @@ func (sc *Smithy) RepoView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	readme, err := GetReadmeFromCommit(commitObj)
-	var formattedReadme string
-	if err != nil {
-		formattedReadme = ""
-	} else {
-		readmeContents, err := readme.Contents()
-		if err != nil {
-			formattedReadme = ""
+	settings := sc.RepoSettings(repo)
+	if settings.Landing == "releases" {
+		http.Redirect(w, r, "/"+repoName+"/releases", http.StatusFound)
+		return
+	}
+
+	readme, err := GetReadmeFromCommit(commitObj, settings.Readme)
+	if settings.Landing != "readme" {
+		if doc, docErr := commitObj.File(settings.Landing); docErr == nil {
+			readme, err = doc, nil
 		} else {
-			formattedReadme = FormatMarkdown(readmeContents)
+			log.Printf("%s landing document %s: %v", repoName, settings.Landing, docErr)
 		}
 	}
+	var formattedReadme string
+	if err == nil {
+		formattedReadme, _ = sc.renders.Readme(readme, fmt.Sprintf("/%s/tree/%s", repoName, main))
+	}
 
-	sc.Render(w, "repo", H{
-		"RepoName": repoName,
-		"Branches": branches,
-		"Tags":     tags,
-		"Readme":   template.HTML(formattedReadme),
-		"Repo":     repo,
+	ctx, cancel := sc.OperationContext(r, "languages")
+	defer cancel()
+	languages, err := sc.languages.Get(ctx, repo, commitObj)
+	if err != nil {
+		log.Printf("%s languages: %v", repoName, err)
+	}
+	activity, err := sc.activity.Get(ctx, repo, commitObj.Hash)
+	if err != nil {
+		log.Printf("%s activity: %v", repoName, err)
+	}
+
+	sc.Render(w, r, "repo", H{
+		"RepoName":  repoName,
+		"Branches":  branches,
+		"Tags":      tags,
+		"Readme":    template.HTML(formattedReadme),
+		"Repo":      repo,
+		"Settings":  settings,
+		"Languages": languages,
+		"Activity":  activity,
+		"Stars":     len(sc.lists.Users(ListStarred, repo.Name)),
+		"Starred":   sc.lists.Contains(ListStarred, sc.CurrentUser(r), repo.Name),
+		"Watching":  sc.lists.Contains(ListWatching, sc.CurrentUser(r), repo.Name),
 	})
 }
 
@@ -198,12 +377,13 @@ func (sc *Smithy) RefsView(w http.ResponseWriter, r *http.Request) {
 		branches = []*plumbing.Reference{}
 	}
 
-	tags, err := ListTags(repo.Repository)
+	tags, err := ListTagInfos(repo.Repository)
 	if err != nil {
-		tags = []*plumbing.Reference{}
+		tags = []TagInfo{}
 	}
+	SortTagsByVersion(tags)
 
-	sc.Render(w, "refs", map[string]any{
+	sc.Render(w, r, "refs", H{
 		"RepoName": repoName,
 		"Branches": branches,
 		"Tags":     tags,
@@ -221,7 +401,7 @@ func (sc *Smithy) TreeView(w http.ResponseWriter, r *http.Request) {
 	var err error
 	refName := sc.GetParam(r, "ref")
 	if refName == "" {
-		refName, _, err = FindMainBranch(repo.Repository)
+		refName, _, err = sc.MainBranch(repo)
 		if err != nil {
 			sc.Error(w, http.StatusInternalServerError, err)
 			return
@@ -242,19 +422,35 @@ func (sc *Smithy) TreeView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Blob pages show the nearest tag, which depends on the refs.
+	refs, err := refsKey(repo.Repository)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	if sc.NotModified(w, r, false, "tree", commitObj.Hash.String(), refs) {
+		return
+	}
+
 	tree, err := commitObj.Tree()
 	if err != nil {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	ctx, cancel := sc.OperationContext(r, "tree")
+	defer cancel()
+
 	// We're looking at the root of the project.  Show a list of files.
 	if treePath == "" {
-		sc.Render(w, "tree", H{
-			"RepoName": repoName,
-			"RefName":  refName,
-			"Files":    tree.Entries,
-			"Path":     treePath,
+		pagination := NewPagination(len(tree.Entries), TREE_PAGE_SIZE, GetPage(r))
+		start, end := pagination.Bounds()
+		sc.Render(w, r, "tree", H{
+			"RepoName":   repoName,
+			"RefName":    refName,
+			"Files":      sc.TreeRows(ctx, repo, commitObj, treePath, tree.Entries[start:end]),
+			"Path":       treePath,
+			"Pagination": pagination,
 		})
 		return
 	}
@@ -265,6 +461,17 @@ func (sc *Smithy) TreeView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Submodules have no tree here; send visitors to wherever they live.
+	if out.Mode == filemode.Submodule {
+		sub := sc.Submodule(repoName, Gitmodules(commitObj)[treePath], out.Hash)
+		if sub.Link == "" {
+			sc.Error(w, http.StatusNotFound, fmt.Errorf("%s is a submodule at %s", treePath, out.Hash))
+			return
+		}
+		http.Redirect(w, r, sub.Link, http.StatusFound)
+		return
+	}
+
 	// We found a subtree.
 	if !out.Mode.IsFile() {
 		subTree, err := tree.Tree(treePath)
@@ -272,13 +479,16 @@ func (sc *Smithy) TreeView(w http.ResponseWriter, r *http.Request) {
 			sc.Error(w, http.StatusInternalServerError, err)
 			return
 		}
-		sc.Render(w, "tree", H{
+		pagination := NewPagination(len(subTree.Entries), TREE_PAGE_SIZE, GetPage(r))
+		start, end := pagination.Bounds()
+		sc.Render(w, r, "tree", H{
 			"RepoName":   repoName,
 			"ParentPath": parentPath,
 			"RefName":    refName,
 			"SubTree":    out.Name,
 			"Path":       treePath,
-			"Files":      subTree.Entries,
+			"Files":      sc.TreeRows(ctx, repo, commitObj, treePath, subTree.Entries[start:end]),
+			"Pagination": pagination,
 		})
 		return
 	}
@@ -288,18 +498,39 @@ func (sc *Smithy) TreeView(w http.ResponseWriter, r *http.Request) {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
-	contents, err := file.Contents()
-	if err != nil {
-		sc.Error(w, http.StatusInternalServerError, err)
-		return
+	prefs := sc.GetPreferences(w, r)
+	// Images are shown from the raw endpoint, addressed by commit so that
+	// browsers can keep them.
+	var image string
+	var highlighted HighlightedBlob
+	if _, ok := ImageType(out.Name); ok {
+		image = fmt.Sprintf("/%s/raw/%s/%s", repoName, commitObj.Hash, treePath)
+	} else {
+		highlighted, err = sc.renders.Highlight(file, out.Name, HighlightOptions{
+			MaxLines:       sc.Config().HighlightMaxLines,
+			MaxBytes:       sc.Config().MaxRenderSize << 10,
+			Wrap:           prefs.Wrap,
+			ShowWhitespace: prefs.Whitespace,
+		})
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
 	}
-	sc.Render(w, "blob", H{
-		"RepoName":   repoName,
-		"RefName":    refName,
-		"File":       out,
-		"ParentPath": parentPath,
-		"Path":       treePath,
-		"Contents":   contents,
+	describe, err := sc.describe.Describe(ctx, repo, commitObj)
+	if err != nil {
+		log.Printf("describe %s: %v", commitObj.Hash, err)
+	}
+	sc.Render(w, r, "blob", H{
+		"RepoName":    repoName,
+		"RefName":     refName,
+		"File":        out,
+		"ParentPath":  parentPath,
+		"Path":        treePath,
+		"Contents":    highlighted,
+		"Image":       image,
+		"Preferences": prefs,
+		"Describe":    describe,
 	})
 }
 
@@ -313,7 +544,7 @@ func (sc *Smithy) LogView(w http.ResponseWriter, r *http.Request) {
 
 	refName := sc.GetParam(r, "ref")
 	if refName == "" {
-		defaultBranchName, _, err := FindMainBranch(repo.Repository)
+		defaultBranchName, _, err := sc.MainBranch(repo)
 		if err != nil {
 			sc.Error(w, http.StatusInternalServerError, err)
 			return
@@ -328,33 +559,105 @@ func (sc *Smithy) LogView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var commits []Commit
-	cIter, err := repo.Repository.Log(&git.LogOptions{From: *revision, Order: git.LogOrderCommitterTime})
-	if err != nil {
-		sc.Error(w, http.StatusInternalServerError, err)
+	ctx, cancel := sc.OperationContext(r, "log")
+	defer cancel()
+
+	treePath := strings.Trim(sc.GetParam(r, "path"), "/")
+	query := r.URL.Query()
+	if query.Get("path") != "" {
+		treePath = strings.Trim(query.Get("path"), "/")
+	}
+	pickaxe := query.Get("pickaxe")
+	if pickaxe != "" && treePath == "" {
+		sc.Error(w, http.StatusBadRequest, fmt.Errorf("pickaxe needs a path"))
 		return
 	}
+	filter := LogFilter{
+		Message: strings.TrimSpace(query.Get("q")),
+		Author:  strings.TrimSpace(query.Get("author")),
+	}
 
-	for i := 1; i <= PAGE_SIZE; i++ {
-		commit, err := cIter.Next()
-		if err == io.EOF {
-			break
+	skip, limit := 0, PAGE_SIZE
+	if pickaxe != "" || !filter.Empty() {
+		// Searches walk the history from the tip and paginate the
+		// matches instead.
+		limit = LOG_SEARCH_LIMIT
+		if pickaxe != "" {
+			limit = PICKAXE_LIMIT
+		}
+		release, err := sc.AcquireHeavy(ctx)
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
 		}
+		defer release()
+	} else {
+		skip = (GetPage(r) - 1) * PAGE_SIZE
+	}
 
-		lines := strings.Split(commit.Message, "\n")
+	depth := sc.HistoryLimits(r).LogDepth
+	if depth > 0 {
+		if skip >= depth {
+			err := fmt.Errorf("History is limited to the latest %d commits", depth)
+			if sc.CurrentUser(r) == "" && sc.AuthEnabled() {
+				err = fmt.Errorf("History is limited to the latest %d commits, log in to see more", depth)
+			}
+			sc.Error(w, http.StatusForbidden, err)
+			return
+		}
+		if skip+limit > depth {
+			limit = depth - skip
+		}
+	}
 
-		c := Commit{
-			Commit:    commit,
-			Subject:   lines[0],
-			ShortHash: commit.Hash.String()[:8],
+	// One more commit than shown tells whether there is an older page.
+	commits, err := CollectCommits(ctx, repo.Repository, *revision, treePath, skip, limit+1)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	page := GetPage(r)
+	olderPage := 0
+	// Searches only look at the latest limit commits.
+	search := pickaxe != "" || !filter.Empty()
+	truncated := search && len(commits) > limit
+	if len(commits) > limit {
+		commits = commits[:limit]
+		if depth == 0 || skip+limit < depth {
+			olderPage = page + 1
+		}
+	}
+	if pickaxe != "" {
+		commits, err = Pickaxe(ctx, commits, treePath, pickaxe)
+		if err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if search {
+		matches := FilterCommits(commits, filter)
+		olderPage = 0
+		start := (page - 1) * PAGE_SIZE
+		if start > len(matches) {
+			start = len(matches)
+		}
+		commits = matches[start:]
+		if len(commits) > PAGE_SIZE {
+			commits = commits[:PAGE_SIZE]
+			olderPage = page + 1
 		}
-		commits = append(commits, c)
 	}
 
-	sc.Render(w, "log", H{
-		"RepoName": repoName,
-		"RefName":  refName,
-		"Commits":  commits,
+	sc.Render(w, r, "log", H{
+		"RepoName":  repoName,
+		"RefName":   refName,
+		"Path":      treePath,
+		"Pickaxe":   pickaxe,
+		"Filter":    filter,
+		"Truncated": truncated,
+		"Commits":   commits,
+		"NewerPage": page - 1,
+		"OlderPage": olderPage,
 	})
 }
 
@@ -378,21 +681,61 @@ func (sc *Smithy) CommitView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	changes, err := GetChanges(commitObj)
+	// Branches, tags and children shown next to the commit depend on the refs.
+	refs, err := refsKey(repo.Repository)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	if sc.NotModified(w, r, false, "commit", commitObj.Hash.String(), refs) {
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "diff")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+
+	changes, err := GetChanges(ctx, commitObj)
 	if err != nil {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	formattedChanges, err := FormatChanges(changes)
+	formattedChanges, err := FormatChanges(ctx, changes)
 	if err != nil {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	sc.Render(w, "commit", H{
+	children, err := sc.children.Children(repo, commitObj.Hash)
+	if err != nil {
+		log.Printf("children of %s: %v", commitObj.Hash, err)
+	}
+
+	contains, err := sc.contains.Contains(ctx, repo, commitObj)
+	if err != nil {
+		log.Printf("refs containing %s: %v", commitObj.Hash, err)
+	}
+
+	describe, err := sc.describe.Describe(ctx, repo, commitObj)
+	if err != nil {
+		log.Printf("describe %s: %v", commitObj.Hash, err)
+	}
+
+	sc.Render(w, r, "commit", H{
 		"RepoName": repoName,
 		"Commit":   commitObj,
+		"Parents":  commitObj.ParentHashes,
+		"Children": children,
+		"Contains": contains,
+		"Describe": describe,
+		"Message":  LinkIssues(commitObj.Message, sc.RepoSettings(repo).Issues),
 		"Changes":  template.HTML(formattedChanges),
 	})
 }
@@ -417,39 +760,43 @@ func (sc *Smithy) PatchView(w http.ResponseWriter, r *http.Request) {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
+	if sc.NotModified(w, r, true, "patch", commitObj.Hash.String(), sc.GetParam(r, "format")) {
+		return
+	}
+
+	ctx, cancel := sc.OperationContext(r, "diff")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
 
-	var patch string
 	if commitObj.NumParents() == 0 {
 		sc.Error(w, http.StatusNotFound, fmt.Errorf("Commit Parents not found"))
 		return
-	} else {
-		parentCommit, err := commitObj.Parent(0)
-
-		if err != nil {
-			sc.Error(w, http.StatusInternalServerError, err)
-			return
-		}
+	}
 
-		patchObj, err := parentCommit.Patch(commitObj)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if strings.TrimPrefix(sc.GetParam(r, "format"), ".") == "diff" {
+		patch, err := CommitPatch(ctx, commitObj)
 		if err != nil {
-			sc.Error(w, http.StatusInternalServerError, err)
+			sc.Error(w, http.StatusInternalServerError, contextError(ctx, err))
 			return
 		}
-		patch = patchObj.String()
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.diff"`, commitObj.Hash.String()[:7]))
+		fmt.Fprint(w, patch.String())
+		return
 	}
-
-	const commitFormatDate = "Mon, 2 Jan 2006 15:04:05 -0700"
-	commitHashStr := fmt.Sprintf("From %s Mon Sep 17 00:00:00 2001", commitObj.Hash)
-	from := fmt.Sprintf("From: %s <%s>", commitObj.Author.Name, commitObj.Author.Email)
-	date := fmt.Sprintf("Date: %s", commitObj.Author.When.Format(commitFormatDate))
-	subject := fmt.Sprintf("Subject: [PATCH] %s", commitObj.Message)
-
-	stats, err := commitObj.Stats()
+	patch, err := FormatPatch(ctx, commitObj, PatchHeader{N: 1, M: 1})
 	if err != nil {
-		sc.Error(w, http.StatusInternalServerError, err)
+		sc.Error(w, http.StatusInternalServerError, contextError(ctx, err))
 		return
 	}
-	fmt.Fprintf(w, "%s\n%s\n%s\n%s\n---\n%s\n%s", commitHashStr, from, date, subject, stats.String(), patch)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, PatchFileName(1, commitObj.Message)))
+	fmt.Fprint(w, patch)
 }
 
 func (sc *Smithy) WriteGitToHttp(w http.ResponseWriter, gitCommand GitCommand) {
@@ -469,7 +816,12 @@ func (sc *Smithy) WriteGitToHttp(w http.ResponseWriter, gitCommand GitCommand) {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
-	nbytes, err := io.Copy(w, stdout)
+	var out io.Writer = w
+	if gitCommand.throttle {
+		out = NewThrottledWriter(w, NewRateLimiter(sc.Config().UploadPackRate*1024), sc.uploadPackLimiter)
+	}
+	nbytes, err := io.Copy(out, stdout)
+	cmd.Wait()
 	if err != nil {
 		sc.Error(w, http.StatusInternalServerError, fmt.Errorf("Error writing to socket: %v", err))
 	} else {
@@ -477,12 +829,27 @@ func (sc *Smithy) WriteGitToHttp(w http.ResponseWriter, gitCommand GitCommand) {
 	}
 }
 
+// CanPush reports whether git-receive-pack may be served for the repository.
+func (sc *Smithy) CanPush(repo RepositoryWithName) bool {
+	return repo.Bare || sc.Config().AllowNonBarePush
+}
+
 func (sc *Smithy) getInfoRefs(w http.ResponseWriter, r *http.Request) {
 	repoName := sc.GetParam(r, "repo")
 	repo, _ := sc.FindRepo(repoName)
 	log.Printf("getInfoRefs for %s", repo.Path)
 	service := r.URL.Query().Get("service")
 	serviceName := strings.Replace(service, "git-", "", 1)
+	if serviceName == "receive-pack" && !sc.CanPush(repo) {
+		sc.Error(w, http.StatusForbidden, fmt.Errorf("Pushing to non-bare repositories is disabled"))
+		return
+	}
+	if serviceName == "receive-pack" && !sc.AuthorizePush(w, r) {
+		return
+	}
+	if serviceName == "upload-pack" {
+		sc.clones.Increment(repo.Name)
+	}
 	w.Header().Set("Content-Type", "application/x-git-"+serviceName+"-advertisement")
 	str := "# service=git-" + serviceName
 	fmt.Fprintf(w, "%.4x%s\n", len(str)+offset, str)
@@ -506,6 +873,7 @@ func (sc *Smithy) uploadPack(w http.ResponseWriter, r *http.Request) {
 	c := GitCommand{
 		procInput: bytes.NewReader(requestBody),
 		args:      []string{"upload-pack", "--stateless-rpc", repo.Path},
+		throttle:  true,
 	}
 	sc.WriteGitToHttp(w, c)
 }
@@ -517,6 +885,13 @@ func (sc *Smithy) receivePack(w http.ResponseWriter, r *http.Request) {
 		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
 		return
 	}
+	if !sc.CanPush(repo) {
+		sc.Error(w, http.StatusForbidden, fmt.Errorf("Pushing to non-bare repositories is disabled"))
+		return
+	}
+	if !sc.AuthorizePush(w, r) {
+		return
+	}
 	log.Printf("receivePack for %s", repo.Path)
 	w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
 	requestBody, err := io.ReadAll(r.Body)
@@ -524,9 +899,23 @@ func (sc *Smithy) receivePack(w http.ResponseWriter, r *http.Request) {
 		sc.Error(w, http.StatusInternalServerError, err)
 		return
 	}
+	before, err := SnapshotRefs(repo.Repository)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
 	c := GitCommand{
 		procInput: bytes.NewReader(requestBody),
 		args:      []string{"receive-pack", "--stateless-rpc", repo.Path},
 	}
 	sc.WriteGitToHttp(w, c)
+
+	after, err := SnapshotRefs(repo.Repository)
+	if err != nil {
+		log.Printf("receivePack for %s: %v", repo.Path, err)
+		return
+	}
+	if updates := DiffRefs(before, after); len(updates) > 0 {
+		sc.OnPush(repo, updates, sc.BaseURL(r))
+	}
 }
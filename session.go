@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	SESSION_COOKIE   = "smithy_session"
+	SESSION_LIFETIME = 30 * 24 * time.Hour
+)
+
+// LoadSessionKey reads the key signing session cookies, creating it on first
+// use so sessions survive restarts. The key is usable even when saving it
+// fails.
+func LoadSessionKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return key, err
+	}
+	return key, os.WriteFile(path, key, 0600)
+}
+
+func (sc *Smithy) signSession(payload string) string {
+	mac := hmac.New(sha256.New, sc.sessionKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkSession reports, in constant time, whether signature is the one
+// signSession makes for payload.
+func (sc *Smithy) checkSession(payload, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(sc.signSession(payload)))
+}
+
+// Session sources: local users are checked against the configuration on
+// every request, OIDC and SAML users carry the permission they were granted
+// at login.
+const (
+	SESSION_LOCAL = "local"
+	SESSION_OIDC  = "oidc"
+	SESSION_SAML  = "saml"
+)
+
+// NewSessionCookie returns a cookie logging user in until it expires. The
+// value is "<source>|<permission>|<expiry>|<user>|<signature>", base64
+// encoded.
+func (sc *Smithy) NewSessionCookie(r *http.Request, source string, permission Permission, user string) *http.Cookie {
+	expires := time.Now().Add(SESSION_LIFETIME)
+	payload := fmt.Sprintf("%s|%d|%d|%s", source, permission, expires.Unix(), user)
+	value := payload + "|" + sc.signSession(payload)
+	return &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(value)),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(sc.BaseURL(r), "https:"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// SessionUser returns the user and permission of a valid session cookie.
+func (sc *Smithy) SessionUser(r *http.Request) (string, Permission) {
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return "", PermissionNone
+	}
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return "", PermissionNone
+	}
+	i := strings.LastIndex(string(value), "|")
+	if i < 0 {
+		return "", PermissionNone
+	}
+	payload, signature := string(value[:i]), string(value[i+1:])
+	if !sc.checkSession(payload, signature) {
+		return "", PermissionNone
+	}
+	fields := strings.SplitN(payload, "|", 4)
+	if len(fields) != 4 {
+		return "", PermissionNone
+	}
+	source, user := fields[0], fields[3]
+	level, _ := strconv.Atoi(fields[1])
+	sec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Now().After(time.Unix(sec, 0)) {
+		return "", PermissionNone
+	}
+	switch source {
+	case SESSION_LOCAL:
+		// Sessions of users removed from the configuration are void.
+		if _, ok := sc.Config().Users[user]; !ok {
+			return "", PermissionNone
+		}
+		return user, PermissionAdmin
+	case SESSION_OIDC:
+		if !sc.Config().OIDC.Enabled() {
+			return "", PermissionNone
+		}
+		return user, Permission(level)
+	case SESSION_SAML:
+		if !sc.Config().SAML.Enabled() {
+			return "", PermissionNone
+		}
+		return user, Permission(level)
+	}
+	return "", PermissionNone
+}
+
+// Identify returns the user a request is made by and what they may do,
+// from their session or HTTP Basic credentials. Local users may do
+// everything. Anonymous requests get "" and PermissionNone.
+func (sc *Smithy) Identify(r *http.Request) (string, Permission) {
+	if r == nil {
+		return "", PermissionNone
+	}
+	if user, permission := sc.ProxyUser(r); user != "" {
+		return user, permission
+	}
+	if user, permission := sc.SessionUser(r); user != "" {
+		return user, permission
+	}
+	user, password, ok := r.BasicAuth()
+	if ok && sc.Config().Users.Check(user, password) {
+		return user, PermissionAdmin
+	}
+	return "", PermissionNone
+}
+
+// CurrentUser returns the user a request is made by, or "".
+func (sc *Smithy) CurrentUser(r *http.Request) string {
+	user, _ := sc.Identify(r)
+	return user
+}
+
+// localRedirect returns next if it is a path on this site, or "/".
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (sc *Smithy) LoginView(w http.ResponseWriter, r *http.Request) {
+	next := localRedirect(r.FormValue("next"))
+	if r.Method != http.MethodPost {
+		sc.Render(w, r, "login", H{
+			"Next": next,
+			"OIDC": sc.Config().OIDC.Enabled(),
+			"SAML": sc.Config().SAML.Enabled(),
+		})
+		return
+	}
+	user, password := r.FormValue("user"), r.FormValue("password")
+	if !sc.Config().Users.Check(user, password) {
+		w.WriteHeader(http.StatusUnauthorized)
+		sc.Render(w, r, "login", H{
+			"Next":  next,
+			"OIDC":  sc.Config().OIDC.Enabled(),
+			"SAML":  sc.Config().SAML.Enabled(),
+			"User":  user,
+			"Error": "Invalid user name or password",
+		})
+		return
+	}
+	http.SetCookie(w, sc.NewSessionCookie(r, SESSION_LOCAL, PermissionAdmin, user))
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (sc *Smithy) LogoutView(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
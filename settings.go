@@ -0,0 +1,196 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoSettings are per-repository options read from the "smithy" section of
+// the repository's git config, e.g. `git config smithy.landing releases`,
+// and from a .smithy.yml file in the repository's git directory, which
+// takes precedence.
+type RepoSettings struct {
+	// Landing selects what the repository page shows: "readme" (the
+	// default), "releases", or the path of a document such as
+	// docs/index.md.
+	Landing string
+	// Readme lists the README candidates, overriding README_NAMES. Set
+	// smithy.readme once per file name.
+	Readme []string
+	// Private repositories are only visible to authenticated users.
+	Private bool
+	// Issues is the URL of the repository's issue tracker; issue numbers
+	// are appended to it, e.g. https://tracker.example.org/project/issues/
+	Issues string
+	// Description defaults to the repository's description file.
+	Description string
+	// DefaultBranch overrides the guessed main branch.
+	DefaultBranch string
+	Website       string
+	// Hidden repositories are left out of the index but stay reachable.
+	Hidden bool
+	// CloneURLs are shown next to the instance's own clone URL, e.g. for
+	// mirrors. Set smithy.cloneurl once per URL.
+	CloneURLs []string
+	// Notice is Markdown shown at the top of the repository's pages, e.g.
+	// "This is a mirror; send patches to ...". Raw HTML is left out.
+	Notice string
+}
+
+// REPO_SETTINGS_FILE is read from the git directory of each repository.
+var REPO_SETTINGS_FILE = ".smithy.yml"
+
+// defaultDescription is what git init writes to the description file.
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository."
+
+// apply sets the settings the override has values for.
+func (settings *RepoSettings) apply(override RepoOverride) {
+	if override.Landing != nil {
+		settings.Landing = *override.Landing
+	}
+	if len(override.Readme) > 0 {
+		settings.Readme = override.Readme
+	}
+	if override.Private != nil {
+		settings.Private = *override.Private
+	}
+	if override.Issues != nil {
+		settings.Issues = *override.Issues
+	}
+	if override.Description != nil {
+		settings.Description = *override.Description
+	}
+	if override.DefaultBranch != nil {
+		settings.DefaultBranch = *override.DefaultBranch
+	}
+	if override.Website != nil {
+		settings.Website = *override.Website
+	}
+	if override.Hidden != nil {
+		settings.Hidden = *override.Hidden
+	}
+	if len(override.CloneURLs) > 0 {
+		settings.CloneURLs = override.CloneURLs
+	}
+	if override.Notice != nil {
+		settings.Notice = *override.Notice
+	}
+}
+
+// RepoSettings returns the settings of a repository, with the overrides of
+// the config file applied. Repositories that are not open are not opened
+// for this; their configuration files are read directly.
+func (sc *Smithy) RepoSettings(repo RepositoryWithName) RepoSettings {
+	var settings RepoSettings
+	if repo.Repository != nil {
+		settings = GetRepoSettings(repo.Repository.Storer)
+	} else if s, err := settingsStorer(repo.Path); err == nil {
+		settings = GetRepoSettings(s)
+	} else {
+		log.Printf("settings of %s: %v", repo.Name, err)
+		settings = RepoSettings{Landing: "readme", Readme: README_NAMES}
+	}
+	if override, ok := sc.Config().Repos[repo.Name]; ok {
+		settings.apply(override)
+	}
+	return settings
+}
+
+// settingsStorer gives access to the git config and files of the
+// repository at repoPath without opening it.
+func settingsStorer(repoPath string) (storage.Storer, error) {
+	fi, err := os.Stat(filepath.Join(repoPath, ".git"))
+	switch {
+	case err == nil && fi.IsDir():
+		return filesystem.NewStorage(osfs.New(filepath.Join(repoPath, ".git")), cache.NewObjectLRU(0)), nil
+	case err == nil:
+		// .git is a file pointing elsewhere; let go-git resolve it.
+		r, err := git.PlainOpen(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		return r.Storer, nil
+	}
+	return filesystem.NewStorage(osfs.New(repoPath), cache.NewObjectLRU(0)), nil
+}
+
+func GetRepoSettings(s storage.Storer) RepoSettings {
+	settings := RepoSettings{Landing: "readme", Readme: README_NAMES}
+	dot, hasDir := s.(interface{ Filesystem() billy.Filesystem })
+	if hasDir {
+		if description, err := readFile(dot.Filesystem(), "description"); err == nil {
+			description = strings.TrimSpace(description)
+			if description != defaultDescription {
+				settings.Description = description
+			}
+		}
+	}
+	if cfg, err := s.Config(); err == nil {
+		section := cfg.Raw.Section("smithy")
+		if landing := section.Option("landing"); landing != "" {
+			settings.Landing = landing
+		}
+		if readme := section.OptionAll("readme"); len(readme) > 0 {
+			settings.Readme = readme
+		}
+		settings.Private, _ = strconv.ParseBool(section.Option("private"))
+		settings.Issues = section.Option("issues")
+		if description := section.Option("description"); description != "" {
+			settings.Description = description
+		}
+		settings.DefaultBranch = section.Option("defaultbranch")
+		settings.Website = section.Option("website")
+		settings.Hidden, _ = strconv.ParseBool(section.Option("hidden"))
+		settings.CloneURLs = section.OptionAll("cloneurl")
+		settings.Notice = section.Option("notice")
+	}
+	if hasDir {
+		data, err := readFile(dot.Filesystem(), REPO_SETTINGS_FILE)
+		if err == nil {
+			var override RepoOverride
+			if err := yaml.Unmarshal([]byte(data), &override); err != nil {
+				log.Printf("%s: %v", REPO_SETTINGS_FILE, err)
+			} else {
+				settings.apply(override)
+			}
+		}
+	}
+	return settings
+}
+
+func readFile(fs billy.Filesystem, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return string(data), err
+}
+
+var issueReference = regexp.MustCompile(`(^|[^\w&])#([0-9]+)\b`)
+
+// LinkIssues escapes a commit message for HTML and links references like
+// #123 to the issue tracker at issues, if any.
+func LinkIssues(message, issues string) template.HTML {
+	escaped := template.HTMLEscapeString(message)
+	if issues == "" {
+		return template.HTML(escaped)
+	}
+	href := strings.ReplaceAll(template.HTMLEscapeString(issues), "$", "$$")
+	return template.HTML(issueReference.ReplaceAllString(escaped, `$1<a href="`+href+`$2">#$2</a>`))
+}
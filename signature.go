@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Signature formats, told apart by the armor of the signature.
+const (
+	SignatureOpenPGP = "openpgp"
+	SignatureSSH     = "ssh"
+	SignatureX509    = "x509"
+)
+
+// SignatureFormat returns the format of an armored signature, or "" if it
+// is not one git makes.
+func SignatureFormat(signature string) string {
+	switch {
+	case strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----"):
+		return SignatureOpenPGP
+	case strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----"):
+		return SignatureSSH
+	case strings.HasPrefix(signature, "-----BEGIN SIGNED MESSAGE-----"):
+		return SignatureX509
+	}
+	return ""
+}
+
+// CommitSignature is the signature of a commit along with the data it
+// signs, so that it can be verified elsewhere, such as against a
+// transparency log, and whether smithy could verify it.
+type CommitSignature struct {
+	Hash      string `json:"hash"`
+	Signed    bool   `json:"signed"`
+	Format    string `json:"format,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Payload is the commit without its signature, as it was signed.
+	Payload  string `json:"payload,omitempty"`
+	Verified bool   `json:"verified"`
+	// Signer is the user whose GPG key made the signature.
+	Signer string `json:"signer,omitempty"`
+	KeyID  string `json:"key_id,omitempty"`
+	// Reason explains Verified: "valid", "unsigned", "unknown_key",
+	// "bad_signature", "email_mismatch" or "unsupported_format".
+	Reason string `json:"reason"`
+}
+
+// signedPayload returns the commit as it was signed.
+func signedPayload(commit *object.Commit) (string, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return "", err
+	}
+	r, err := encoded.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	payload, err := io.ReadAll(r)
+	return string(payload), err
+}
+
+// VerifyCommit checks the signature of a commit against the GPG keys of the
+// user profiles. A signature only counts as verified when the committer's
+// email is one of the signer's, if the signer lists any.
+func (sc *Smithy) VerifyCommit(commit *object.Commit) (CommitSignature, error) {
+	out := CommitSignature{Hash: commit.Hash.String(), Reason: "unsigned"}
+	if commit.PGPSignature == "" {
+		return out, nil
+	}
+	payload, err := signedPayload(commit)
+	if err != nil {
+		return out, err
+	}
+	out.Signed = true
+	out.Signature = commit.PGPSignature
+	out.Payload = payload
+	out.Format = SignatureFormat(commit.PGPSignature)
+	if out.Format != SignatureOpenPGP {
+		out.Reason = "unsupported_format"
+		return out, nil
+	}
+
+	users := make([]string, 0, len(sc.Config().Profiles))
+	for user := range sc.Config().Profiles {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	out.Reason = "unknown_key"
+	for _, user := range users {
+		profile := sc.Config().Profiles[user]
+		keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(strings.Join(profile.GPGKeys, "\n")))
+		if err != nil {
+			continue
+		}
+		entity, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(payload), strings.NewReader(commit.PGPSignature), nil)
+		if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+			continue
+		}
+		if err != nil {
+			out.Reason = "bad_signature"
+			return out, nil
+		}
+		out.Signer = user
+		out.KeyID = entity.PrimaryKey.KeyIdString()
+		matches := len(profile.Emails) == 0
+		for _, email := range profile.Emails {
+			matches = matches || strings.EqualFold(email, commit.Committer.Email)
+		}
+		if !matches {
+			out.Reason = "email_mismatch"
+			return out, nil
+		}
+		out.Verified, out.Reason = true, "valid"
+		return out, nil
+	}
+	return out, nil
+}
+
+// APICommitSignature returns the signature of a commit and whether it
+// verifies.
+func (sc *Smithy) APICommitSignature(w http.ResponseWriter, r *http.Request) {
+	repo, ok := sc.APIFindRepo(w, r)
+	if !ok {
+		return
+	}
+	commit, err := ResolveCommit(repo.Repository, sc.GetParam(r, "hash"))
+	if err != nil {
+		sc.JSONError(w, http.StatusNotFound, err)
+		return
+	}
+	out, err := sc.VerifyCommit(commit)
+	if err != nil {
+		sc.JSONError(w, http.StatusInternalServerError, fmt.Errorf("signature of %s: %v", commit.Hash, err))
+		return
+	}
+	sc.JSON(w, http.StatusOK, out)
+}
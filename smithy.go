@@ -2,29 +2,43 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"log"
+	"net/http"
 	"os"
 	"path"
 	"sort"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
 )
 
 type RepositoryWithName struct {
 	Name       string
 	Path       string
 	Repository *git.Repository
+	// Bare is false for working-copy repositories, whose objects are read
+	// from the .git directory inside Path.
+	Bare bool
 }
 
 type RepositoryByName []RepositoryWithName
@@ -46,56 +60,400 @@ func (r ReferenceByName) Less(i, j int) bool {
 }
 
 type Smithy struct {
-	Root     string
+	Root string
+	// config is replaced as a whole on reload; see Config. configMu
+	// serializes replacements.
+	config   *atomic.Pointer[SmithyConfig]
+	configMu *sync.Mutex
+	// reposMu guards repos, which rescans and admin actions change while
+	// requests look repositories up.
+	reposMu  *sync.RWMutex
 	repos    map[string]RepositoryWithName
 	template *template.Template
+	heavy    chan struct{}
+	objects  cache.Object
+	// handles keeps the most recently used repositories open.
+	handles  *RepoHandles
+	children *ChildCache
+	contains *ContainsCache
+	describe *DescribeCache
+	// lastChanges backs the last change column of tree views.
+	lastChanges *LastChangeCache
+	files       *FileListCache
+	stats       *StatsCache
+	summaries   *RepoSummaryCache
+	profiles    *ProfileCache
+	authors     *ContributorCache
+	languages   *LanguageCache
+	activity    *ActivityCache
+	pages       *PageCache
+	renders     *RenderCache
+	clones      *CloneCounter
+	lists       *RepoLists
+	transfers   *Transfers
+	banner      *Announcement
+	links       *LinkReport
+	// index is nil unless the search index is enabled.
+	index *SearchIndex
+	// uploadPackLimiter is shared by all upload-pack responses.
+	uploadPackLimiter *RateLimiter
+	// pageLimiter and heavyLimiter throttle requests per client.
+	pageLimiter  *RequestLimiter
+	heavyLimiter *RequestLimiter
+	// wellKnown holds the handlers served under /.well-known/.
+	wellKnown map[string]http.HandlerFunc
+	// sessionKey signs session cookies.
+	sessionKey []byte
+	oidc       *OIDCDiscovery
+	saml       *samlIdentity
+	authHook   *AuthHookCache
+	// generation counts reloads, which may change how pages render.
+	generation *atomic.Int64
 }
 
-func NewSmithy(root string) Smithy {
-	return Smithy{
-		Root: root,
+func NewSmithy(config SmithyConfig) Smithy {
+	if config.MaxHeavyOperations < 1 {
+		config.MaxHeavyOperations = 1
 	}
+	sc := Smithy{
+		Root:        config.Root,
+		config:      new(atomic.Pointer[SmithyConfig]),
+		configMu:    new(sync.Mutex),
+		reposMu:     new(sync.RWMutex),
+		heavy:       make(chan struct{}, config.MaxHeavyOperations),
+		handles:     NewRepoHandles(config.MaxOpenRepos),
+		children:    NewChildCache(),
+		contains:    NewContainsCache(),
+		describe:    NewDescribeCache(),
+		lastChanges: NewLastChangeCache(),
+		files:       NewFileListCache(),
+		stats:       NewStatsCache(),
+		summaries:   NewRepoSummaryCache(),
+		profiles:    NewProfileCache(),
+		authors:     NewContributorCache(),
+		languages:   NewLanguageCache(),
+		activity:    NewActivityCache(),
+		pages:       NewPageCache(),
+		renders:     NewRenderCache(config.RenderCacheSize * 1024 * 1024),
+		clones:      NewCloneCounter(config.DataPath("clones.json")),
+		lists:       NewRepoLists(config.DataPath("lists.json")),
+		transfers:   NewTransfers(config.DataPath("transfers.json")),
+		banner:      NewAnnouncement(config.DataPath("announcement.md")),
+		links:       &LinkReport{},
+
+		uploadPackLimiter: NewRateLimiter(config.UploadPackGlobalRate * 1024),
+		pageLimiter:       NewRequestLimiter(config.RateLimit.Pages),
+		heavyLimiter:      NewRequestLimiter(config.RateLimit.Heavy),
+		wellKnown:         make(map[string]http.HandlerFunc),
+		oidc:              &OIDCDiscovery{},
+		saml:              &samlIdentity{},
+		authHook:          NewAuthHookCache(),
+		generation:        new(atomic.Int64),
+	}
+	sc.transfers.Apply(&config)
+	sc.config.Store(&config)
+	if config.SharedObjectCache {
+		sc.objects = sc.NewObjectCache()
+	}
+	if config.SearchIndex {
+		sc.index = NewSearchIndex(config.DataPath("search"))
+	}
+	key, err := LoadSessionKey(config.DataPath("session.key"))
+	if err != nil {
+		log.Printf("sessions will not survive a restart: %v", err)
+	}
+	sc.sessionKey = key
+	return sc
+}
+
+// Config returns the configuration in effect. Reloads replace it rather
+// than change it, so it must be treated as read-only.
+func (sc *Smithy) Config() *SmithyConfig {
+	return sc.config.Load()
+}
+
+// UpdateConfig replaces the configuration with what update makes of a copy
+// of it. Updates are applied one at a time.
+func (sc *Smithy) UpdateConfig(update func(config *SmithyConfig) error) error {
+	sc.configMu.Lock()
+	defer sc.configMu.Unlock()
+	config := sc.Config().Clone()
+	if err := update(config); err != nil {
+		return err
+	}
+	sc.config.Store(config)
+	return nil
+}
+
+func (sc *Smithy) NewObjectCache() cache.Object {
+	size := cache.FileSize(sc.Config().ObjectCacheSize) * cache.MiByte
+	if size <= 0 {
+		size = cache.DefaultMaxSize
+	}
+	return cache.NewObjectLRU(size)
+}
+
+// OpenRepository opens the repository at repoPath with an object cache sized
+// according to the configuration.
+func (sc *Smithy) OpenRepository(repoPath string) (*git.Repository, error) {
+	objects := sc.objects
+	if objects == nil {
+		objects = sc.NewObjectCache()
+	}
+
+	var dot, wt billy.Filesystem
+	fi, err := os.Stat(path.Join(repoPath, ".git"))
+	switch {
+	case err == nil && fi.IsDir():
+		dot = osfs.New(path.Join(repoPath, ".git"))
+		wt = osfs.New(repoPath)
+	case err == nil:
+		// .git is a file pointing elsewhere (worktrees, submodules); let
+		// go-git resolve it.
+		return git.PlainOpen(repoPath)
+	default:
+		if _, err := os.Stat(path.Join(repoPath, "HEAD")); err != nil {
+			return nil, git.ErrRepositoryNotExists
+		}
+		dot = osfs.New(repoPath)
+	}
+	storage := filesystem.NewStorage(dot, objects)
+	if sc.Config().ReplaceRefs {
+		return git.Open(NewReplaceStorage(storage), wt)
+	}
+	return git.Open(storage, wt)
 }
 
+// Close releases resources held by the repository handle.
+func (rwn RepositoryWithName) Close() error {
+	if c, ok := rwn.Repository.Storer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// AcquireHeavy waits for a free slot to run an expensive operation. The
+// returned function must be called to release the slot.
+func (sc *Smithy) AcquireHeavy(ctx context.Context) (func(), error) {
+	select {
+	case sc.heavy <- struct{}{}:
+		return func() { <-sc.heavy }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// AddRepository registers a repository and keeps its open handle.
 func (sc *Smithy) AddRepository(rwn RepositoryWithName) {
-	sc.repos[rwn.Name] = rwn
+	sc.reposMu.Lock()
+	sc.repos[rwn.Name] = RepositoryWithName{Name: rwn.Name, Path: rwn.Path, Bare: rwn.Bare}
+	sc.reposMu.Unlock()
+	sc.handles.Add(rwn)
+}
+
+// RemoveRepository forgets a repository and closes its handle.
+func (sc *Smithy) RemoveRepository(name string) {
+	sc.reposMu.Lock()
+	delete(sc.repos, name)
+	sc.reposMu.Unlock()
+	sc.handles.Remove(name)
+}
+
+// knownRepo looks a repository up by name without opening it.
+func (sc *Smithy) knownRepo(name string) (RepositoryWithName, bool) {
+	sc.reposMu.RLock()
+	defer sc.reposMu.RUnlock()
+	known, exists := sc.repos[name]
+	return known, exists
+}
+
+// RepoCount returns how many repositories are known.
+func (sc *Smithy) RepoCount() int {
+	sc.reposMu.RLock()
+	defer sc.reposMu.RUnlock()
+	return len(sc.repos)
+}
+
+// LoadAllRepositories scans the root for repositories. They are only opened
+// when first needed.
+func (sc *Smithy) LoadAllRepositories() error {
+	repos, err := sc.scanRoot()
+	if err != nil {
+		return err
+	}
+	sc.reposMu.Lock()
+	sc.repos = repos
+	sc.reposMu.Unlock()
+	sc.handles.Clear()
+	return nil
+}
+
+// scanRoot lists the repositories in the root without opening them.
+// Directories that are not repositories themselves are namespaces: the
+// repositories below them are named "namespace/project".
+func (sc *Smithy) scanRoot() (map[string]RepositoryWithName, error) {
+	repos := make(map[string]RepositoryWithName)
+	if err := scanNamespace(sc.Root, "", repos); err != nil {
+		return nil, err
+	}
+	return repos, nil
 }
 
-func (sc *Smithy) LoadAllRepositories() (err error) {
-	files, err := os.ReadDir(sc.Root)
+func scanNamespace(dir, namespace string, repos map[string]RepositoryWithName) error {
+	files, err := os.ReadDir(dir)
 	if err != nil {
-		return
+		return err
 	}
-	sc.repos = make(map[string]RepositoryWithName)
 	for _, f := range files {
-		repoPath := path.Join(sc.Root, f.Name())
-		r, err := git.PlainOpen(repoPath)
-		if err != nil {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		name := path.Join(namespace, f.Name())
+		repoPath := path.Join(dir, f.Name())
+		if isRepository(repoPath) {
+			_, err := os.Stat(path.Join(repoPath, ".git"))
+			repos[name] = RepositoryWithName{Name: name, Path: repoPath, Bare: err != nil}
+			continue
+		}
+		if err := scanNamespace(repoPath, name, repos); err != nil {
+			log.Printf("scanning %s: %v", repoPath, err)
+		}
+	}
+	return nil
+}
+
+// RepoNamespace returns the namespace part of a repository name, or "" for
+// repositories directly in the root.
+func RepoNamespace(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// splitRepoPath splits a URL path, without its leading slash, into the
+// longest leading name that known accepts and the rest. Without such a
+// name the first segment is taken.
+func splitRepoPath(p string, known func(name string) bool) (name, rest string) {
+	for i := len(p); i > 0; i = strings.LastIndex(p[:i], "/") {
+		if known(p[:i]) {
+			return p[:i], strings.TrimPrefix(p[i:], "/")
+		}
+	}
+	name, rest, _ = strings.Cut(p, "/")
+	return name, rest
+}
+
+// RepoGroup is the repositories of one namespace.
+type RepoGroup struct {
+	Namespace string
+	Repos     []RepositoryWithName
+	// Collapsed groups only show their header, which links to Toggle.
+	Collapsed bool
+	Toggle    string
+}
+
+// GroupByNamespace groups repositories sorted by name by the first segment
+// of their namespace, so that team/a and team/tools/b are both in team.
+// Repositories in the root come first.
+func GroupByNamespace(repos []RepositoryWithName) []RepoGroup {
+	var root RepoGroup
+	var groups []RepoGroup
+	index := make(map[string]int)
+	for _, repo := range repos {
+		ns, _, _ := strings.Cut(RepoNamespace(repo.Name), "/")
+		if ns == "" {
+			root.Repos = append(root.Repos, repo)
 			continue
 		}
-		key := f.Name()
-		rwn := RepositoryWithName{
-			Name:       f.Name(),
-			Repository: r,
-			Path:       repoPath,
+		i, ok := index[ns]
+		if !ok {
+			i = len(groups)
+			index[ns] = i
+			groups = append(groups, RepoGroup{Namespace: ns})
 		}
-		sc.repos[key] = rwn
+		groups[i].Repos = append(groups[i].Repos, repo)
 	}
-	return
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Namespace < groups[j].Namespace
+	})
+	if len(root.Repos) > 0 {
+		groups = append([]RepoGroup{root}, groups...)
+	}
+	return groups
 }
 
+// MainBranch returns the default branch of a repository: the one set with
+// the default_branch setting if it exists, or else the one HEAD points at.
+func (sc *Smithy) MainBranch(repo RepositoryWithName) (string, *plumbing.Hash, error) {
+	if branch := sc.RepoSettings(repo).DefaultBranch; branch != "" {
+		if ref, err := repo.Repository.Reference(plumbing.NewBranchReferenceName(branch), true); err == nil {
+			hash := ref.Hash()
+			return branch, &hash, nil
+		}
+	}
+	return FindMainBranch(repo.Repository)
+}
+
+// RepoExists reports whether name is a known repository.
+func (sc *Smithy) RepoExists(name string) bool {
+	_, exists := sc.knownRepo(name)
+	return exists
+}
+
+// IsBare reports whether the repository has no working tree.
+func IsBare(r *git.Repository) bool {
+	cfg, err := r.Config()
+	if err != nil {
+		return true
+	}
+	return cfg.Core.IsBare
+}
+
+// GetRepositories lists the known repositories by name without opening
+// them. Use openRepo for those whose contents are needed.
 func (sc *Smithy) GetRepositories() []RepositoryWithName {
-	var repos []RepositoryWithName
-	for _, repo := range sc.repos {
-		repos = append(repos, repo)
+	sc.reposMu.RLock()
+	repos := make([]RepositoryWithName, 0, len(sc.repos))
+	for _, known := range sc.repos {
+		repos = append(repos, known)
 	}
+	sc.reposMu.RUnlock()
 	sort.Sort(RepositoryByName(repos))
 	return repos
 }
 
 func (sc *Smithy) FindRepo(slug string) (RepositoryWithName, bool) {
-	value, exists := sc.repos[slug]
-	return value, exists
+	known, exists := sc.knownRepo(slug)
+	if !exists {
+		return RepositoryWithName{}, false
+	}
+	repo, err := sc.openRepo(known)
+	if err != nil {
+		log.Printf("opening %s: %v", slug, err)
+		return RepositoryWithName{}, false
+	}
+	return repo, true
+}
+
+// ResolveAlias returns the name of the repository an alias or a former
+// name of a transferred repository refers to. Aliases never shadow existing
+// repositories.
+func (sc *Smithy) ResolveAlias(name string) (string, bool) {
+	if _, exists := sc.knownRepo(name); exists {
+		return "", false
+	}
+	target, ok := sc.Config().Aliases[name]
+	if !ok {
+		target, ok = sc.transfers.Target(name)
+	}
+	if !ok {
+		return "", false
+	}
+	if _, exists := sc.knownRepo(target); !exists {
+		return "", false
+	}
+	return target, true
 }
 
 type Commit struct {
@@ -104,8 +462,138 @@ type Commit struct {
 	ShortHash string
 }
 
-func (c *Commit) CommitDate() string {
-	return c.Commit.Author.When.Format(time.DateTime)
+// NewCommit summarizes a commit for lists, abbreviating its hash to abbrev
+// hex digits. See AbbrevLength.
+func NewCommit(commit *object.Commit, abbrev int) Commit {
+	lines := strings.Split(commit.Message, "\n")
+	return Commit{
+		Commit:    commit,
+		Subject:   lines[0],
+		ShortHash: Abbreviate(commit.Hash, abbrev),
+	}
+}
+
+// CollectCommits walks the history reachable from the given commit, newest
+// first, skipping the first skip commits and returning at most limit. If
+// treePath is not empty only commits touching that file or directory count.
+func CollectCommits(ctx context.Context, r *git.Repository, from plumbing.Hash, treePath string, skip, limit int) ([]Commit, error) {
+	options := &git.LogOptions{From: from, Order: git.LogOrderCommitterTime}
+	if treePath != "" {
+		options.PathFilter = func(p string) bool {
+			return p == treePath || strings.HasPrefix(p, treePath+"/")
+		}
+	}
+	cIter, err := r.Log(options)
+	if err != nil {
+		return nil, err
+	}
+	defer cIter.Close()
+
+	for i := 0; i < skip; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := cIter.Next(); err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	abbrev := AbbrevLength(r)
+	var commits []Commit
+	for i := 1; i <= limit; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, NewCommit(commit, abbrev))
+	}
+	return commits, nil
+}
+
+func countInFile(commit *object.Commit, path, term string) (int, error) {
+	f, err := commit.File(path)
+	if err == object.ErrFileNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return 0, err
+	}
+	return strings.Count(contents, term), nil
+}
+
+// Pickaxe keeps the commits that change the number of occurrences of term in
+// the file at path, like `git log -S`.
+func Pickaxe(ctx context.Context, commits []Commit, path, term string) ([]Commit, error) {
+	var out []Commit
+	for _, c := range commits {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		after, err := countInFile(c.Commit, path, term)
+		if err != nil {
+			return nil, err
+		}
+		before := 0
+		if parent, err := c.Commit.Parent(0); err == nil {
+			before, err = countInFile(parent, path, term)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if before != after {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// LogFilter selects commits whose message contains Message and whose
+// author's name or email contains Author, ignoring case. Empty fields match
+// every commit.
+type LogFilter struct {
+	Message string
+	Author  string
+}
+
+func (f LogFilter) Empty() bool {
+	return f.Message == "" && f.Author == ""
+}
+
+func (f LogFilter) Match(commit *object.Commit) bool {
+	if f.Message != "" && !strings.Contains(strings.ToLower(commit.Message), strings.ToLower(f.Message)) {
+		return false
+	}
+	if f.Author != "" {
+		author := strings.ToLower(f.Author)
+		if !strings.Contains(strings.ToLower(commit.Author.Name), author) &&
+			!strings.Contains(strings.ToLower(commit.Author.Email), author) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterCommits keeps the commits matching filter.
+func FilterCommits(commits []Commit, filter LogFilter) []Commit {
+	var out []Commit
+	for _, c := range commits {
+		if filter.Match(c.Commit) {
+			out = append(out, c)
+		}
+	}
+	return out
 }
 
 func ReferenceCollector(it storer.ReferenceIter) ([]*plumbing.Reference, error) {
@@ -128,12 +616,43 @@ func ReferenceCollector(it storer.ReferenceIter) ([]*plumbing.Reference, error)
 	return refs, nil
 }
 
+// HIDDEN_REFS are patterns of refs left out of ref lists, dropdowns and
+// feeds, such as refs/heads/ci/*. A pattern ending in /* hides everything
+// below that prefix, others are matched with path.Match. Hidden refs can
+// still be fetched and named explicitly. The -hide-refs flag sets it.
+var HIDDEN_REFS []string
+
+// RefHidden reports whether name matches one of HIDDEN_REFS.
+func RefHidden(name plumbing.ReferenceName) bool {
+	for _, pattern := range HIDDEN_REFS {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasSuffix(prefix, "/") && strings.HasPrefix(name.String(), prefix) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name.String()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleRefs drops the refs hidden by HIDDEN_REFS.
+func visibleRefs(refs []*plumbing.Reference) []*plumbing.Reference {
+	visible := refs[:0]
+	for _, ref := range refs {
+		if !RefHidden(ref.Name()) {
+			visible = append(visible, ref)
+		}
+	}
+	return visible
+}
+
 func ListBranches(r *git.Repository) ([]*plumbing.Reference, error) {
 	it, err := r.Branches()
 	if err != nil {
 		return []*plumbing.Reference{}, err
 	}
-	return ReferenceCollector(it)
+	refs, err := ReferenceCollector(it)
+	return visibleRefs(refs), err
 }
 
 func ListTags(r *git.Repository) ([]*plumbing.Reference, error) {
@@ -141,24 +660,30 @@ func ListTags(r *git.Repository) ([]*plumbing.Reference, error) {
 	if err != nil {
 		return []*plumbing.Reference{}, err
 	}
-	return ReferenceCollector(it)
+	refs, err := ReferenceCollector(it)
+	return visibleRefs(refs), err
 }
 
-func GetReadmeFromCommit(commit *object.Commit) (*object.File, error) {
-	options := []string{
-		"readme",
-		"README",
-		"readme.md",
-		"README.md",
-		"readme.txt",
-		"README.txt",
-		"readme.markdown",
-		"README.markdown",
-	}
-
-	for _, opt := range options {
-		f, err := commit.File(opt)
+// README_NAMES are the files tried, in order, when looking for the README
+// of a repository. The -readme flag and smithy.readme override it.
+var README_NAMES = []string{
+	"readme",
+	"README",
+	"readme.md",
+	"README.md",
+	"readme.txt",
+	"README.txt",
+	"readme.markdown",
+	"README.markdown",
+	"README.adoc",
+	"README.rst",
+	"docs/README.md",
+}
 
+// GetReadmeFromCommit returns the first of names present in the commit.
+func GetReadmeFromCommit(commit *object.Commit, names []string) (*object.File, error) {
+	for _, name := range names {
+		f, err := commit.File(name)
 		if err == nil {
 			return f, nil
 		}
@@ -166,24 +691,72 @@ func GetReadmeFromCommit(commit *object.Commit) (*object.File, error) {
 	return nil, errors.New("no valid readme")
 }
 
-func FormatMarkdown(input string) string {
+// FormatReadme renders Markdown and plain text documents as Markdown, and
+// other formats as preformatted text. Relative links resolve against the
+// document's directory in the tree at treeURL.
+func FormatReadme(name, contents, treeURL string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case "", ".md", ".markdown", ".txt":
+		return FormatMarkdown(contents, treeURL, name)
+	}
+	return "<pre>" + template.HTMLEscapeString(contents) + "</pre>"
+}
+
+// MARKDOWN_EXTENSIONS are the goldmark extensions, by their names in
+// markdownExtenders, that FormatMarkdown enables. The -markdown-extensions
+// flag overrides it.
+var MARKDOWN_EXTENSIONS = []string{"table", "strikethrough", "linkify", "tasklist", "footnote"}
+
+var markdownExtenders = map[string]goldmark.Extender{
+	"table":          extension.Table,
+	"strikethrough":  extension.Strikethrough,
+	"linkify":        extension.Linkify,
+	"tasklist":       extension.TaskList,
+	"footnote":       extension.Footnote,
+	"definitionlist": extension.DefinitionList,
+	"typographer":    extension.Typographer,
+	"gfm":            extension.GFM,
+}
+
+// FormatMarkdown renders Markdown without raw HTML. When treeURL is set,
+// relative links are rewritten to point into that tree, as seen from the
+// document at docPath.
+func FormatMarkdown(input, treeURL, docPath string) string {
 	var buf bytes.Buffer
-	markdown := goldmark.New(
-		goldmark.WithExtensions(
-			highlighting.NewHighlighting(
-				highlighting.WithFormatOptions(
-					html.WithClasses(true),
-				),
+	extensions := []goldmark.Extender{
+		highlighting.NewHighlighting(
+			highlighting.WithFormatOptions(
+				html.WithClasses(true),
 			),
 		),
-	)
+	}
+	for _, name := range MARKDOWN_EXTENSIONS {
+		if ext, ok := markdownExtenders[name]; ok {
+			extensions = append(extensions, ext)
+		}
+	}
+	markdown := goldmark.New(goldmark.WithExtensions(extensions...))
+	if treeURL != "" {
+		markdown.Parser().AddOptions(parser.WithASTTransformers(
+			util.Prioritized(linkRewriter{treeURL: treeURL, dir: path.Dir(docPath)}, 100),
+		))
+	}
 	if err := markdown.Convert([]byte(input), &buf); err != nil {
 		return input
 	}
 	return buf.String()
 }
 
+// FindMainBranch returns the branch HEAD points at. Without a usable HEAD,
+// as in repositories whose HEAD names a branch that was never pushed, it
+// guesses main or master, or else takes the first branch.
 func FindMainBranch(repo *git.Repository) (string, *plumbing.Hash, error) {
+	if head, err := repo.Storer.Reference(plumbing.HEAD); err == nil && head.Type() == plumbing.SymbolicReference {
+		if ref, err := repo.Reference(head.Target(), true); err == nil && head.Target().IsBranch() {
+			hash := ref.Hash()
+			return head.Target().Short(), &hash, nil
+		}
+	}
 	branches, _ := ListBranches(repo)
 
 	if len(branches) == 0 {
@@ -204,7 +777,26 @@ func FindMainBranch(repo *git.Repository) (string, *plumbing.Hash, error) {
 	return branch, revision, err
 }
 
-func GetChanges(commit *object.Commit) (object.Changes, error) {
+// contextError replaces go-git's generic cancellation error with the reason
+// the context was cancelled.
+func contextError(ctx context.Context, err error) error {
+	if err == object.ErrCanceled && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ResolveCommit returns the commit a revision (branch, tag, hash, ...)
+// points at.
+func ResolveCommit(r *git.Repository, rev string) (*object.Commit, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, err
+	}
+	return r.CommitObject(*hash)
+}
+
+func GetChanges(ctx context.Context, commit *object.Commit) (object.Changes, error) {
 	var changes object.Changes
 	var parentTree *object.Tree
 
@@ -221,8 +813,8 @@ func GetChanges(commit *object.Commit) (object.Changes, error) {
 		return changes, err
 	}
 
-	return object.DiffTree(parentTree, currentTree)
-
+	changes, err = object.DiffTreeContext(ctx, parentTree, currentTree)
+	return changes, contextError(ctx, err)
 }
 
 // PatchHTML returns an HTML representation of a patch
@@ -237,12 +829,12 @@ func PatchHTML(p object.Patch) string {
 }
 
 // FormatChanges spits out something similar to `git diff`
-func FormatChanges(changes object.Changes) (string, error) {
+func FormatChanges(ctx context.Context, changes object.Changes) (string, error) {
 	var s []string
 	for _, change := range changes {
-		patch, err := change.Patch()
+		patch, err := change.PatchContext(ctx)
 		if err != nil {
-			return "", err
+			return "", contextError(ctx, err)
 		}
 		s = append(s, PatchHTML(*patch))
 	}
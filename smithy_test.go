@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestSplitRepoPath(t *testing.T) {
+	known := map[string]bool{
+		"project":            true,
+		"team/project":       true,
+		"team/project/fork":  true,
+		"team/tools/project": true,
+	}
+	tests := []struct {
+		path, name, rest string
+	}{
+		{"project", "project", ""},
+		{"project/tree/main", "project", "tree/main"},
+		{"team/project/log/main", "team/project", "log/main"},
+		{"team/project/fork/commits", "team/project/fork", "commits"},
+		{"team/tools/project/raw/main/README.md", "team/tools/project", "raw/main/README.md"},
+		{"unknown/tree/main", "unknown", "tree/main"},
+		{"team/unknown", "team", "unknown"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		name, rest := splitRepoPath(tt.path, func(name string) bool { return known[name] })
+		if name != tt.name || rest != tt.rest {
+			t.Errorf("splitRepoPath(%q) = %q, %q, want %q, %q", tt.path, name, rest, tt.name, tt.rest)
+		}
+	}
+}
+
+func TestRepoNamespace(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"project", ""},
+		{"team/project", "team"},
+		{"team/tools/project", "team/tools"},
+	}
+	for _, tt := range tests {
+		if got := RepoNamespace(tt.name); got != tt.want {
+			t.Errorf("RepoNamespace(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
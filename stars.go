@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ListStarred  = "starred"
+	ListWatching = "watching"
+)
+
+// RepoLists keeps the repositories users starred or watch, saved to a JSON
+// file as list -> user -> repositories.
+type RepoLists struct {
+	mu    sync.Mutex
+	path  string
+	lists map[string]map[string][]string
+}
+
+func NewRepoLists(path string) *RepoLists {
+	return &RepoLists{path: path}
+}
+
+func (rl *RepoLists) load() {
+	if rl.lists != nil {
+		return
+	}
+	rl.lists = make(map[string]map[string][]string)
+	data, err := os.ReadFile(rl.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &rl.lists)
+	}
+	if err != nil {
+		log.Printf("repository lists: %v", err)
+	}
+}
+
+// Repos returns the repositories in a user's list.
+func (rl *RepoLists) Repos(list, user string) []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.load()
+	return append([]string(nil), rl.lists[list][user]...)
+}
+
+// Contains reports whether a repository is in a user's list.
+func (rl *RepoLists) Contains(list, user, repo string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.load()
+	return containsString(rl.lists[list][user], repo)
+}
+
+// Users returns the users who have a repository in their list.
+func (rl *RepoLists) Users(list, repo string) []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.load()
+	var users []string
+	for user, repos := range rl.lists[list] {
+		if containsString(repos, repo) {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Set adds a repository to a user's list or removes it, and saves the lists.
+func (rl *RepoLists) Set(list, user, repo string, on bool) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.load()
+	if rl.lists[list] == nil {
+		rl.lists[list] = make(map[string][]string)
+	}
+	var repos []string
+	for _, name := range rl.lists[list][user] {
+		if name != repo {
+			repos = append(repos, name)
+		}
+	}
+	if on {
+		repos = append(repos, repo)
+		sort.Strings(repos)
+	}
+	if len(repos) == 0 {
+		delete(rl.lists[list], user)
+	} else {
+		rl.lists[list][user] = repos
+	}
+	return rl.save()
+}
+
+// Rename replaces a repository in all lists by its new name.
+func (rl *RepoLists) Rename(old, new string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.load()
+	for _, users := range rl.lists {
+		for user, repos := range users {
+			if !containsString(repos, old) {
+				continue
+			}
+			renamed := []string{new}
+			for _, name := range repos {
+				if name != old && name != new {
+					renamed = append(renamed, name)
+				}
+			}
+			sort.Strings(renamed)
+			users[user] = renamed
+		}
+	}
+	return rl.save()
+}
+
+func (rl *RepoLists) save() error {
+	data, err := json.Marshal(rl.lists)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(rl.path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(rl.path, data, 0644)
+	}
+	return err
+}
+
+// ListView stars or watches a repository for the logged in user, or stops
+// doing so when the form value "on" is "0".
+func (sc *Smithy) ListView(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		sc.Error(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed"))
+		return
+	}
+	user := sc.CurrentUser(r)
+	if user == "" {
+		sc.RequestAuth(w, r)
+		return
+	}
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	list := ListStarred
+	if sc.GetParam(r, "action") == "watch" {
+		list = ListWatching
+	}
+	if err := sc.lists.Set(list, user, repo.Name, r.FormValue("on") != "0"); err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.pages.Purge()
+	http.Redirect(w, r, "/"+repo.Name, http.StatusSeeOther)
+}
+
+// NotifyWatchers mails the users watching a repository about the commits
+// of a push. Users without an email address in their profile only get
+// the entries in their watch feed. Users who may no longer read the
+// repository are not mailed.
+func (sc *Smithy) NotifyWatchers(repo RepositoryWithName, pushes []RefPush, baseURL string) {
+	if !sc.Config().Mail.Enabled() {
+		return
+	}
+	var body strings.Builder
+	for _, u := range pushes {
+		commits := u.Commits
+		if len(commits) == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "%s: %d new commits\n", u.Name.Short(), len(commits))
+		for _, c := range commits {
+			subject, _, _ := strings.Cut(c.Message, "\n")
+			fmt.Fprintf(&body, "  %s %s (%s)\n", c.ID[:8], subject, c.Author.Name)
+		}
+		fmt.Fprintf(&body, "  %s/%s/log/%s\n\n", baseURL, repo.Name, u.Name.Short())
+	}
+	if body.Len() == 0 {
+		return
+	}
+	subject := fmt.Sprintf("[%s] Push to %s", sc.Config().Title, repo.Name)
+	for _, user := range sc.lists.Users(ListWatching, repo.Name) {
+		emails := sc.Config().Profiles[user].Emails
+		if len(emails) == 0 || !sc.UserCanRead(user, repo) {
+			continue
+		}
+		go func(user, address string) {
+			if err := sc.Config().Mail.SendMail([]string{address}, subject, body.String()); err != nil {
+				log.Printf("notifying %s: %v", user, err)
+			}
+		}(user, emails[0])
+	}
+}
+
+// WatchFeedView serves an Atom feed of the recent commits of the
+// repositories a user watches.
+func (sc *Smithy) WatchFeedView(w http.ResponseWriter, r *http.Request) {
+	user := sc.GetParam(r, "user")
+	// Watch lists are private to their users and admins.
+	if current, permission := sc.Identify(r); current != user && permission < PermissionAdmin {
+		if current == "" {
+			sc.RequestAuth(w, r)
+		} else {
+			sc.Error(w, http.StatusForbidden, fmt.Errorf("Permission denied"))
+		}
+		return
+	}
+	ctx, cancel := sc.OperationContext(r, "log")
+	defer cancel()
+
+	baseURL := sc.BaseURL(r)
+	selfURL := baseURL + r.URL.Path
+	feed := CommitFeed(baseURL, "~"+user, fmt.Sprintf("Watched by %s", user), selfURL, nil)
+	for _, name := range sc.lists.Repos(ListWatching, user) {
+		repo, exists := sc.FindRepo(name)
+		if !exists || !sc.CanRead(r, repo) {
+			continue
+		}
+		_, head, err := sc.MainBranch(repo)
+		if err != nil {
+			continue
+		}
+		commits, err := CollectCommits(ctx, repo.Repository, *head, "", 0, FEED_SIZE)
+		if err != nil {
+			log.Printf("watch feed of %s: %v", name, err)
+			continue
+		}
+		for _, entry := range CommitFeed(baseURL, name, "", selfURL, commits).Entries {
+			entry.Title = fmt.Sprintf("%s: %s", name, entry.Title)
+			feed.Entries = append(feed.Entries, entry)
+		}
+	}
+	// RFC 3339 times in UTC sort as strings.
+	sort.SliceStable(feed.Entries, func(i, j int) bool {
+		return feed.Entries[i].Updated > feed.Entries[j].Updated
+	})
+	if len(feed.Entries) > FEED_SIZE {
+		feed.Entries = feed.Entries[:FEED_SIZE]
+	}
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+	sc.WriteFeed(w, r, feed)
+}
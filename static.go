@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// DEFAULT_LOGO is shown in the page header unless the static directory has
+// a logo.svg.
+var DEFAULT_LOGO = "https://lsong.org/icon.svg"
+
+// StaticFile returns the path of name in Config.StaticDir, if that is a
+// regular file.
+func (sc *Smithy) StaticFile(name string) (string, bool) {
+	if sc.Config().StaticDir == "" {
+		return "", false
+	}
+	p := filepath.Join(sc.Config().StaticDir, filepath.FromSlash(path.Clean("/"+name)))
+	fi, err := os.Stat(p)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	return p, true
+}
+
+// StaticView serves the files of the static directory under /static/, and
+// the icons pages link to from the root.
+func (sc *Smithy) StaticView(w http.ResponseWriter, r *http.Request) {
+	p, ok := sc.StaticFile(sc.GetParam(r, "file"))
+	if !ok {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("File not found"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeFile(w, r, p)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+type RepoStats struct {
+	Commits   int
+	DiskUsage int64
+	// Activity counts commits per month ("2006-01").
+	Activity map[string]int
+}
+
+type statsEntry struct {
+	refsKey string
+	stats   RepoStats
+}
+
+// StatsCache keeps per-repository statistics, recomputed whenever the refs
+// of a repository move.
+type StatsCache struct {
+	mu      sync.Mutex
+	entries map[string]*statsEntry
+}
+
+func NewStatsCache() *StatsCache {
+	return &StatsCache{entries: make(map[string]*statsEntry)}
+}
+
+func DiskUsage(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+func ComputeRepoStats(ctx context.Context, repo RepositoryWithName) (RepoStats, error) {
+	stats := RepoStats{Activity: make(map[string]int)}
+	var err error
+	stats.DiskUsage, err = DiskUsage(repo.Path)
+	if err != nil {
+		return stats, err
+	}
+
+	cIter, err := repo.Repository.Log(&git.LogOptions{All: true})
+	if err != nil {
+		// Empty repositories have nothing to walk.
+		return stats, nil
+	}
+	defer cIter.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		commit, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Commits++
+		stats.Activity[commit.Committer.When.UTC().Format("2006-01")]++
+	}
+	return stats, nil
+}
+
+type commitCount struct {
+	head  plumbing.Hash
+	count int
+}
+
+// commitCounts caches CountCommits per repository and head.
+var commitCounts sync.Map
+
+// CountCommits counts the commits reachable from head.
+func CountCommits(ctx context.Context, repo RepositoryWithName, head plumbing.Hash) (int, error) {
+	if v, ok := commitCounts.Load(repo.Name); ok && v.(commitCount).head == head {
+		return v.(commitCount).count, nil
+	}
+	cIter, err := repo.Repository.Log(&git.LogOptions{From: head})
+	if err != nil {
+		return 0, err
+	}
+	defer cIter.Close()
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		_, err := cIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	commitCounts.Store(repo.Name, commitCount{head: head, count: count})
+	return count, nil
+}
+
+// Get returns the statistics of the repository, computing them if its refs
+// changed since the last call.
+func (c *StatsCache) Get(ctx context.Context, repo RepositoryWithName) (RepoStats, error) {
+	key, err := refsKey(repo.Repository)
+	if err != nil {
+		return RepoStats{}, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[repo.Name]
+	c.mu.Unlock()
+	if ok && entry.refsKey == key {
+		return entry.stats, nil
+	}
+
+	stats, err := ComputeRepoStats(ctx, repo)
+	if err != nil {
+		return stats, err
+	}
+	c.mu.Lock()
+	c.entries[repo.Name] = &statsEntry{refsKey: key, stats: stats}
+	c.mu.Unlock()
+	return stats, nil
+}
+
+type MonthActivity struct {
+	Month   string `json:"month"`
+	Commits int    `json:"commits"`
+}
+
+type InstanceStats struct {
+	Repositories int             `json:"repositories"`
+	Bare         int             `json:"bare"`
+	DiskUsage    int64           `json:"disk_usage"`
+	Commits      int             `json:"commits"`
+	Activity     []MonthActivity `json:"activity"`
+}
+
+func (sc *Smithy) StatsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := sc.OperationContext(r, "stats")
+	defer cancel()
+
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		sc.JSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer release()
+
+	var out InstanceStats
+	activity := make(map[string]int)
+	for _, known := range sc.VisibleRepositories(r) {
+		repo, err := sc.openRepo(known)
+		if err != nil {
+			log.Printf("opening %s: %v", known.Name, err)
+			continue
+		}
+		stats, err := sc.stats.Get(ctx, repo)
+		if err != nil {
+			sc.JSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		out.Repositories++
+		if repo.Bare {
+			out.Bare++
+		}
+		out.DiskUsage += stats.DiskUsage
+		out.Commits += stats.Commits
+		for month, n := range stats.Activity {
+			activity[month] += n
+		}
+	}
+	out.Activity = []MonthActivity{}
+	for month, n := range activity {
+		out.Activity = append(out.Activity, MonthActivity{Month: month, Commits: n})
+	}
+	sort.Slice(out.Activity, func(i, j int) bool {
+		return out.Activity[i].Month < out.Activity[j].Month
+	})
+	sc.JSON(w, http.StatusOK, out)
+}
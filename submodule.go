@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// SubmoduleLink is a submodule entry of a tree: the commit it is pinned to
+// and where to browse it.
+type SubmoduleLink struct {
+	URL       string
+	Commit    plumbing.Hash
+	ShortHash string
+	// Link is the page of the pinned commit if the submodule is hosted
+	// here, or else a web address of its repository. It is empty when
+	// neither is known.
+	Link string
+}
+
+// Gitmodules returns the submodule URLs listed in the .gitmodules file of a
+// commit, by path.
+func Gitmodules(commit *object.Commit) map[string]string {
+	urls := make(map[string]string)
+	file, err := commit.File(".gitmodules")
+	if err != nil {
+		return urls
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return urls
+	}
+	modules := config.NewModules()
+	if err := modules.Unmarshal([]byte(contents)); err != nil {
+		return urls
+	}
+	for _, m := range modules.Submodules {
+		urls[m.Path] = m.URL
+	}
+	return urls
+}
+
+// Submodule describes a submodule of repoName cloned from rawURL and
+// pinned to hash.
+func (sc *Smithy) Submodule(repoName, rawURL string, hash plumbing.Hash) *SubmoduleLink {
+	sub := &SubmoduleLink{URL: rawURL, Commit: hash, ShortHash: Abbreviate(hash, 0)}
+	if name, ok := sc.localSubmodule(repoName, rawURL); ok {
+		sub.Link = "/" + name + "/tree/" + hash.String()
+	} else {
+		sub.Link = submoduleWebURL(rawURL)
+	}
+	return sub
+}
+
+// localSubmodule returns the name of the repository of this instance a
+// submodule URL points at. URLs relative to the superproject, clone URLs of
+// the instance and paths below the root all count.
+func (sc *Smithy) localSubmodule(repoName, rawURL string) (string, bool) {
+	var name string
+	switch {
+	case strings.HasPrefix(rawURL, "./") || strings.HasPrefix(rawURL, "../"):
+		name = path.Join(path.Dir(repoName), rawURL)
+	case strings.HasPrefix(rawURL, "/") || strings.HasPrefix(rawURL, "file://"):
+		root, err := filepath.Abs(sc.Root)
+		if err != nil {
+			return "", false
+		}
+		rel, err := filepath.Rel(root, strings.TrimPrefix(rawURL, "file://"))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", false
+		}
+		name = filepath.ToSlash(rel)
+	default:
+		prefixes := []string{sc.Config().CloneURL() + "/"}
+		if sc.Config().Host != "" {
+			prefixes = append(prefixes,
+				"https://"+sc.Config().Host+"/",
+				"http://"+sc.Config().Host+"/",
+				"ssh://git@"+sc.Config().Host+"/",
+				"git@"+sc.Config().Host+":")
+		}
+		for _, prefix := range prefixes {
+			if rest, ok := strings.CutPrefix(rawURL, prefix); ok {
+				name = rest
+				break
+			}
+		}
+	}
+	name = strings.Trim(name, "/")
+	if name == "" || strings.HasPrefix(name, "..") {
+		return "", false
+	}
+	for _, candidate := range []string{name, name + ".git", strings.TrimSuffix(name, ".git")} {
+		if sc.RepoExists(candidate) {
+			return candidate, true
+		}
+		if target, ok := sc.ResolveAlias(candidate); ok {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// submoduleWebURL turns a clone URL into the address of the repository on
+// the web, assuming the host serves it over https at the same path, as
+// hosting sites do.
+func submoduleWebURL(rawURL string) string {
+	// scp-like syntax: git@host:owner/repo.git
+	if !strings.Contains(rawURL, "://") {
+		userHost, repoPath, ok := strings.Cut(rawURL, ":")
+		if !ok || strings.Contains(userHost, "/") {
+			return ""
+		}
+		_, host, _ := strings.Cut(userHost, "@")
+		if host == "" {
+			host = userHost
+		}
+		rawURL = "https://" + host + "/" + strings.TrimPrefix(repoPath, "/")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "ssh", "git", "git+ssh", "ssh+git":
+		u.Scheme = "https"
+		u.Host = u.Hostname()
+	default:
+		return ""
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, ".git")
+	return u.String()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// TagInfo describes a tag, peeled to the commit it points at.
+type TagInfo struct {
+	Name      string
+	Ref       *plumbing.Reference
+	Commit    *object.Commit
+	Annotated bool
+	// Tagger, Date and Message come from the tag object for annotated tags
+	// and from the commit otherwise.
+	Tagger  object.Signature
+	Date    time.Time
+	Message string
+}
+
+func GetTagInfo(r *git.Repository, ref *plumbing.Reference) (TagInfo, error) {
+	info := TagInfo{Name: ref.Name().Short(), Ref: ref}
+	tag, err := r.TagObject(ref.Hash())
+	switch err {
+	case nil:
+		commit, err := tag.Commit()
+		if err != nil {
+			return info, err
+		}
+		info.Commit = commit
+		info.Annotated = true
+		info.Tagger = tag.Tagger
+		info.Date = tag.Tagger.When
+		info.Message = tag.Message
+	case plumbing.ErrObjectNotFound:
+		commit, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return info, err
+		}
+		info.Commit = commit
+		info.Tagger = commit.Committer
+		info.Date = commit.Committer.When
+		info.Message = commit.Message
+	default:
+		return info, err
+	}
+	return info, nil
+}
+
+// ListTagInfos returns the tags of the repository, newest first. Tags not
+// pointing at commits are skipped.
+func ListTagInfos(r *git.Repository) ([]TagInfo, error) {
+	tags, err := ListTags(r)
+	if err != nil {
+		return nil, err
+	}
+	var infos []TagInfo
+	for _, ref := range tags {
+		info, err := GetTagInfo(r, ref)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Date.After(infos[j].Date)
+	})
+	return infos, nil
+}
+
+// tagVersion splits tags like v1.2.3 or 1.2.3-rc1 into their numbers and
+// the rest. It reports false for tags not starting with a number.
+func tagVersion(name string) ([]int, string, bool) {
+	s := strings.TrimPrefix(name, "v")
+	var numbers []int
+	for {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			break
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, n)
+		s = s[i:]
+		if !strings.HasPrefix(s, ".") || len(s) < 2 || s[1] < '0' || s[1] > '9' {
+			break
+		}
+		s = s[1:]
+	}
+	return numbers, s, len(numbers) > 0
+}
+
+// compareVersions orders versions by their numbers, missing ones counting
+// as 0. A release comes after its pre-releases, such as 1.0-rc1.
+func compareVersions(a, b []int, aRest, bRest string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aRest == bRest:
+		return 0
+	case aRest == "":
+		return 1
+	case bRest == "":
+		return -1
+	}
+	return strings.Compare(aRest, bRest)
+}
+
+// SortTagsByVersion puts tags named like versions first, the highest
+// version first, followed by the others newest first.
+func SortTagsByVersion(tags []TagInfo) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, aRest, aOK := tagVersion(tags[i].Name)
+		b, bRest, bOK := tagVersion(tags[j].Name)
+		switch {
+		case aOK && bOK:
+			if c := compareVersions(a, b, aRest, bRest); c != 0 {
+				return c > 0
+			}
+		case aOK != bOK:
+			return aOK
+		}
+		return tags[i].Date.After(tags[j].Date)
+	})
+}
+
+// TagFeedView serves an Atom feed of the tags of a repository.
+func (sc *Smithy) TagFeedView(w http.ResponseWriter, r *http.Request) {
+	repoName := sc.GetParam(r, "repo")
+	repo, exists := sc.FindRepo(repoName)
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+
+	tags, err := ListTagInfos(repo.Repository)
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(tags) > FEED_SIZE {
+		tags = tags[:FEED_SIZE]
+	}
+
+	baseURL := sc.BaseURL(r)
+	selfURL := baseURL + r.URL.Path
+	feed := AtomFeed{
+		Title: fmt.Sprintf("%s: tags", repoName),
+		ID:    selfURL,
+		Links: []AtomLink{
+			{Href: selfURL, Rel: "self"},
+			{Href: fmt.Sprintf("%s/%s/releases", baseURL, repoName), Rel: "alternate"},
+		},
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+	}
+	if len(tags) > 0 {
+		feed.Updated = tags[0].Date.UTC().Format(time.RFC3339)
+	}
+	for _, tag := range tags {
+		link := fmt.Sprintf("%s/%s/releases#%s", baseURL, repoName, tag.Name)
+		feed.Entries = append(feed.Entries, AtomEntry{
+			Title:   tag.Name,
+			ID:      fmt.Sprintf("%s/%s/tree/%s#%s", baseURL, repoName, tag.Commit.Hash, tag.Name),
+			Updated: tag.Date.UTC().Format(time.RFC3339),
+			Author: AtomAuthor{
+				Name:  tag.Tagger.Name,
+				Email: tag.Tagger.Email,
+			},
+			Link:    AtomLink{Href: link, Rel: "alternate"},
+			Content: AtomContent{Type: "text", Body: tag.Message},
+		})
+	}
+	sc.WriteFeed(w, r, feed)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket allowing a sustained number of bytes per
+// second, with bursts of up to one second worth of data.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter for bytesPerSec, or nil if bytesPerSec is
+// not positive, meaning unlimited.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// Burst is the largest n Wait accepts.
+func (l *RateLimiter) Burst() int {
+	return int(l.rate)
+}
+
+// Wait blocks until n bytes may be sent.
+func (l *RateLimiter) Wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// ThrottledWriter writes to w no faster than every one of its limiters
+// allows.
+type ThrottledWriter struct {
+	w        io.Writer
+	limiters []*RateLimiter
+	chunk    int
+}
+
+func NewThrottledWriter(w io.Writer, limiters ...*RateLimiter) io.Writer {
+	tw := &ThrottledWriter{w: w, chunk: 32 * 1024}
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		tw.limiters = append(tw.limiters, l)
+		if l.Burst() < tw.chunk {
+			tw.chunk = l.Burst()
+		}
+	}
+	if len(tw.limiters) == 0 {
+		return w
+	}
+	return tw
+}
+
+func (tw *ThrottledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > tw.chunk {
+			n = tw.chunk
+		}
+		for _, l := range tw.limiters {
+			l.Wait(n)
+		}
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig selects how smithy serves HTTPS, if at all: with a certificate
+// and key from disk, or with certificates obtained from Let's Encrypt for
+// ACMEDomains.
+type TLSConfig struct {
+	CertFile    string
+	KeyFile     string
+	ACMEDomains StringList
+	ACMEEmail   string
+	// ACMEHTTPAddr serves ACME HTTP-01 challenges and redirects everything
+	// else to HTTPS. Empty disables it.
+	ACMEHTTPAddr string
+}
+
+// ServerConfig tunes the HTTP server. Zero timeouts mean none, which suits
+// long clones and large archive downloads on slow connections.
+type ServerConfig struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxHeaderBytes limits the size of request headers, 0 for Go's
+	// default of 1 MiB.
+	MaxHeaderBytes int
+	// HTTP2 offers HTTP/2 to HTTPS clients.
+	HTTP2 bool
+}
+
+// ListenAndServe serves handler on the configured listener, over HTTPS
+// when TLS is configured.
+func (sc *Smithy) ListenAndServe(handler http.Handler) error {
+	l, err := sc.Listen()
+	if err != nil {
+		return err
+	}
+	tuning := sc.Config().Server
+	server := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       tuning.ReadTimeout,
+		ReadHeaderTimeout: tuning.ReadHeaderTimeout,
+		WriteTimeout:      tuning.WriteTimeout,
+		IdleTimeout:       tuning.IdleTimeout,
+		MaxHeaderBytes:    tuning.MaxHeaderBytes,
+	}
+	if !tuning.HTTP2 {
+		// A non-nil map turns off the automatic HTTP/2 support.
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	cfg := sc.Config().TLS
+	switch {
+	case len(cfg.ACMEDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(sc.Config().DataPath("acme")),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEHTTPAddr != "" {
+			go func() {
+				log.Fatal(http.ListenAndServe(cfg.ACMEHTTPAddr, manager.HTTPHandler(nil)))
+			}()
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		return server.ServeTLS(l, "", "")
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return server.ServeTLS(l, cfg.CertFile, cfg.KeyFile)
+	}
+	return server.Serve(l)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Transfers remembers the former names of transferred repositories, saved
+// to a JSON file as former name -> current name.
+type Transfers struct {
+	mu    sync.Mutex
+	path  string
+	names map[string]string
+}
+
+func NewTransfers(path string) *Transfers {
+	return &Transfers{path: path}
+}
+
+func (t *Transfers) load() {
+	if t.names != nil {
+		return
+	}
+	t.names = make(map[string]string)
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &t.names)
+	}
+	if err != nil {
+		log.Printf("transfers: %v", err)
+	}
+}
+
+// Target returns the current name of a repository formerly called name.
+func (t *Transfers) Target(name string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load()
+	target, ok := t.names[name]
+	return target, ok
+}
+
+// FormerNames lists the names a repository now called name had, sorted.
+func (t *Transfers) FormerNames(name string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load()
+	names := []string{}
+	for former, target := range t.names {
+		if target == name {
+			names = append(names, former)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Add records that a repository was renamed from old to new. Names that
+// referred to old refer to new from now on.
+func (t *Transfers) Add(old, new string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load()
+	for former, target := range t.names {
+		if target == old {
+			t.names[former] = new
+		}
+	}
+	delete(t.names, new)
+	t.names[old] = new
+	data, err := json.Marshal(t.names)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(t.path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(t.path, data, 0644)
+	}
+	return err
+}
+
+// Apply moves the per-repository settings of config that still use former
+// names to the current ones, so that webhooks, digests and overrides keep
+// applying to transferred repositories.
+func (t *Transfers) Apply(config *SmithyConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load()
+	for old, new := range t.names {
+		if override, ok := config.Repos[old]; ok {
+			if _, exists := config.Repos[new]; !exists {
+				config.Repos[new] = override
+			}
+			delete(config.Repos, old)
+		}
+		if hooks, ok := config.Webhooks[old]; ok {
+			config.Webhooks[new] = append(config.Webhooks[new], hooks...)
+			delete(config.Webhooks, old)
+		}
+		if addresses, ok := config.Digests[old]; ok {
+			config.Digests[new] = append(config.Digests[new], addresses...)
+			delete(config.Digests, old)
+		}
+		for alias, target := range config.Aliases {
+			if target == old {
+				config.Aliases[alias] = new
+			}
+		}
+	}
+}
+
+// TransferRepository moves a repository to another name, usually in another
+// namespace. The old name redirects to the new one, and the stars, watchers,
+// clone counts and search index of the repository move along.
+func (sc *Smithy) TransferRepository(repo RepositoryWithName, name string) (RepositoryWithName, error) {
+	if !ValidRepoName(name) || sc.inRepository(name) {
+		return RepositoryWithName{}, fmt.Errorf("Invalid repository name %q", name)
+	}
+	repoPath := filepath.Join(sc.Root, name)
+	if _, err := os.Stat(repoPath); err == nil {
+		return RepositoryWithName{}, fmt.Errorf("Repository %s already exists", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(repoPath), 0755); err != nil {
+		return RepositoryWithName{}, err
+	}
+	repo.Close()
+	if err := os.Rename(repo.Path, repoPath); err != nil {
+		return RepositoryWithName{}, err
+	}
+	sc.RemoveRepository(repo.Name)
+	// Remove the namespace if it is empty now; this fails otherwise.
+	if ns := RepoNamespace(repo.Name); ns != "" {
+		os.Remove(filepath.Join(sc.Root, ns))
+	}
+
+	r, err := sc.OpenRepository(repoPath)
+	if err != nil {
+		return RepositoryWithName{}, err
+	}
+	rwn := RepositoryWithName{
+		Name:       name,
+		Path:       repoPath,
+		Repository: r,
+		Bare:       IsBare(r),
+	}
+	sc.AddRepository(rwn)
+
+	if err := sc.transfers.Add(repo.Name, name); err != nil {
+		log.Printf("transfer %s: %v", repo.Name, err)
+	}
+	sc.UpdateConfig(func(config *SmithyConfig) error {
+		sc.transfers.Apply(config)
+		return nil
+	})
+	if err := sc.lists.Rename(repo.Name, name); err != nil {
+		log.Printf("transfer %s: %v", repo.Name, err)
+	}
+	if err := sc.clones.Rename(repo.Name, name); err != nil {
+		log.Printf("transfer %s: %v", repo.Name, err)
+	}
+	if err := sc.index.Rename(repo.Name, name); err != nil {
+		log.Printf("transfer %s: %v", repo.Name, err)
+	}
+	sc.generation.Add(1)
+	sc.pages.Purge()
+	log.Printf("transferred %s to %s", repo.Name, name)
+	return rwn, nil
+}
+
+// TransferView moves the repository named by the form value "name" to the
+// form value "to".
+func (sc *Smithy) TransferView(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		sc.Render(w, r, "transfer", H{
+			"Repos": sc.GetRepositories(),
+		})
+		return
+	}
+	repo, exists := sc.FindRepo(r.FormValue("name"))
+	if !exists {
+		sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+		return
+	}
+	moved, err := sc.TransferRepository(repo, r.FormValue("to"))
+	if err != nil {
+		sc.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	http.Redirect(w, r, "/"+moved.Name, http.StatusSeeOther)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTransfersAdd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transfers.json")
+	transfers := NewTransfers(path)
+	for _, rename := range [][2]string{
+		{"a", "b"},
+		{"b", "c"},
+		{"x", "team/x"},
+		// Moving back makes the current name stop redirecting.
+		{"team/x", "x"},
+	} {
+		if err := transfers.Add(rename[0], rename[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The names are saved and read back by a fresh instance.
+	reloaded := NewTransfers(path)
+	tests := []struct {
+		name, target string
+		ok           bool
+	}{
+		{"a", "c", true},
+		{"b", "c", true},
+		{"c", "", false},
+		{"team/x", "x", true},
+		{"x", "", false},
+	}
+	for _, tt := range tests {
+		target, ok := reloaded.Target(tt.name)
+		if target != tt.target || ok != tt.ok {
+			t.Errorf("Target(%q) = %q, %v, want %q, %v", tt.name, target, ok, tt.target, tt.ok)
+		}
+	}
+	if got := reloaded.FormerNames("c"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("FormerNames(c) = %q", got)
+	}
+}
+
+func TestTransfersApply(t *testing.T) {
+	transfers := NewTransfers(filepath.Join(t.TempDir(), "transfers.json"))
+	if err := transfers.Add("old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfers.Add("gone", "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	described := func(description string) RepoOverride {
+		return RepoOverride{Description: &description}
+	}
+	config := SmithyConfig{
+		Repos: map[string]RepoOverride{
+			"old":  described("from the old name"),
+			"gone": described("from the old name"),
+			"kept": described("set for the new name"),
+		},
+		Webhooks: WebhookMap{
+			"old": {{URL: "https://ci.example.org/old"}},
+			"new": {{URL: "https://ci.example.org/new"}},
+		},
+		Digests: DigestMap{"old": {"alice@example.org"}},
+		Aliases: StringMap{"legacy": "old", "other": "elsewhere"},
+	}
+	transfers.Apply(&config)
+
+	wantRepos := map[string]RepoOverride{
+		"new":  described("from the old name"),
+		"kept": described("set for the new name"),
+	}
+	if !reflect.DeepEqual(config.Repos, wantRepos) {
+		t.Errorf("Repos = %+v, want %+v", config.Repos, wantRepos)
+	}
+	wantHooks := WebhookMap{"new": {{URL: "https://ci.example.org/new"}, {URL: "https://ci.example.org/old"}}}
+	if !reflect.DeepEqual(config.Webhooks, wantHooks) {
+		t.Errorf("Webhooks = %+v, want %+v", config.Webhooks, wantHooks)
+	}
+	if want := (DigestMap{"new": {"alice@example.org"}}); !reflect.DeepEqual(config.Digests, want) {
+		t.Errorf("Digests = %+v, want %+v", config.Digests, want)
+	}
+	if want := (StringMap{"legacy": "new", "other": "elsewhere"}); !reflect.DeepEqual(config.Aliases, want) {
+		t.Errorf("Aliases = %+v, want %+v", config.Aliases, want)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashEntry is a deleted repository kept until its retention expires. Its
+// ID is the directory name in the trash: "<name>.<unix time of deletion>",
+// with the name escaped by trashNameEscaper.
+type TrashEntry struct {
+	ID        string
+	Name      string
+	DeletedAt time.Time
+	ExpiresAt time.Time
+}
+
+// trashNameEscaper turns a repository name into a single directory name,
+// escaping "/" as "~2f" and "~" itself as "~7e" so that names round-trip.
+// trashNameUnescaper reverses it; a lone "~", as in trash entries from
+// before "~" was escaped, stands for "/".
+var (
+	trashNameEscaper   = strings.NewReplacer("~", "~7e", "/", "~2f")
+	trashNameUnescaper = strings.NewReplacer("~2f", "/", "~7e", "~", "~", "/")
+)
+
+func (sc *Smithy) TrashDir() string {
+	return sc.Config().DataPath("trash")
+}
+
+func (sc *Smithy) parseTrashEntry(id string) (TrashEntry, bool) {
+	i := strings.LastIndex(id, ".")
+	if i <= 0 {
+		return TrashEntry{}, false
+	}
+	sec, err := strconv.ParseInt(id[i+1:], 10, 64)
+	if err != nil {
+		return TrashEntry{}, false
+	}
+	deleted := time.Unix(sec, 0)
+	return TrashEntry{
+		ID:        id,
+		Name:      trashNameUnescaper.Replace(id[:i]),
+		DeletedAt: deleted,
+		ExpiresAt: deleted.Add(sc.Config().TrashRetention),
+	}, true
+}
+
+// TrashRepository moves a repository out of the root into the trash.
+func (sc *Smithy) TrashRepository(repo RepositoryWithName) error {
+	if err := os.MkdirAll(sc.TrashDir(), 0755); err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s.%d", trashNameEscaper.Replace(repo.Name), time.Now().Unix())
+	repo.Close()
+	if err := os.Rename(repo.Path, filepath.Join(sc.TrashDir(), id)); err != nil {
+		return err
+	}
+	sc.RemoveRepository(repo.Name)
+	log.Printf("moved %s to trash as %s", repo.Name, id)
+	return nil
+}
+
+// ListTrash returns the trashed repositories, most recently deleted first.
+func (sc *Smithy) ListTrash() ([]TrashEntry, error) {
+	files, err := os.ReadDir(sc.TrashDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	for _, f := range files {
+		if entry, ok := sc.parseTrashEntry(f.Name()); ok && f.IsDir() {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// RestoreRepository moves a trashed repository back to its original name.
+func (sc *Smithy) RestoreRepository(id string) (RepositoryWithName, error) {
+	entry, ok := sc.parseTrashEntry(id)
+	if !ok || id != filepath.Base(id) || !ValidRepoName(entry.Name) {
+		return RepositoryWithName{}, fmt.Errorf("Trash entry not found")
+	}
+	repoPath := filepath.Join(sc.Root, entry.Name)
+	if _, err := os.Stat(repoPath); err == nil {
+		return RepositoryWithName{}, fmt.Errorf("Repository %s already exists", entry.Name)
+	}
+	if err := os.MkdirAll(filepath.Dir(repoPath), 0755); err != nil {
+		return RepositoryWithName{}, err
+	}
+	if err := os.Rename(filepath.Join(sc.TrashDir(), id), repoPath); err != nil {
+		return RepositoryWithName{}, err
+	}
+	r, err := sc.OpenRepository(repoPath)
+	if err != nil {
+		return RepositoryWithName{}, err
+	}
+	rwn := RepositoryWithName{
+		Name:       entry.Name,
+		Path:       repoPath,
+		Repository: r,
+		Bare:       IsBare(r),
+	}
+	sc.AddRepository(rwn)
+	log.Printf("restored %s from trash", entry.Name)
+	return rwn, nil
+}
+
+// PurgeTrash permanently removes repositories whose retention has expired.
+func (sc *Smithy) PurgeTrash() error {
+	entries, err := sc.ListTrash()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if now.Before(entry.ExpiresAt) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(sc.TrashDir(), entry.ID)); err != nil {
+			return err
+		}
+		log.Printf("purged %s from trash", entry.ID)
+	}
+	return nil
+}
+
+// PurgeTrashPeriodically runs PurgeTrash every interval, forever.
+func (sc *Smithy) PurgeTrashPeriodically(interval time.Duration) {
+	for {
+		if err := sc.PurgeTrash(); err != nil {
+			log.Printf("purging trash: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// TrashView lists trashed repositories. Posting a repository name moves it
+// to the trash.
+func (sc *Smithy) TrashView(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		repo, exists := sc.FindRepo(r.FormValue("name"))
+		if !exists {
+			sc.Error(w, http.StatusNotFound, fmt.Errorf("Repository not found"))
+			return
+		}
+		if err := sc.TrashRepository(repo); err != nil {
+			sc.Error(w, http.StatusInternalServerError, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+		return
+	}
+	entries, err := sc.ListTrash()
+	if err != nil {
+		sc.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+	sc.Render(w, r, "trash", H{
+		"Entries": entries,
+		"Repos":   sc.GetRepositories(),
+	})
+}
+
+func (sc *Smithy) RestoreView(w http.ResponseWriter, r *http.Request) {
+	if !sc.AuthorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repo, err := sc.RestoreRepository(sc.GetParam(r, "id"))
+	if err != nil {
+		sc.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	http.Redirect(w, r, "/"+repo.Name, http.StatusSeeOther)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashNameEscaping(t *testing.T) {
+	tests := []struct {
+		name    string
+		escaped string
+	}{
+		{"project", "project"},
+		{"team/project", "team~2fproject"},
+		{"team/tools/project.git", "team~2ftools~2fproject.git"},
+		{"~user/project", "~7euser~2fproject"},
+		{"odd~2fname", "odd~7e2fname"},
+	}
+	for _, tt := range tests {
+		escaped := trashNameEscaper.Replace(tt.name)
+		if escaped != tt.escaped {
+			t.Errorf("escaping %q = %q, want %q", tt.name, escaped, tt.escaped)
+		}
+		if got := trashNameUnescaper.Replace(escaped); got != tt.name {
+			t.Errorf("unescaping %q = %q, want %q", escaped, got, tt.name)
+		}
+	}
+}
+
+func TestParseTrashEntry(t *testing.T) {
+	sc := NewSmithy(SmithyConfig{Root: t.TempDir(), TrashRetention: time.Hour})
+
+	tests := []struct {
+		id   string
+		name string
+		ok   bool
+	}{
+		{"project.1700000000", "project", true},
+		{"project.git.1700000000", "project.git", true},
+		{"team~2fproject.1700000000", "team/project", true},
+		{"team~project.1700000000", "team/project", true},
+		{"~7euser.1700000000", "~user", true},
+		{"project", "", false},
+		{"project.", "", false},
+		{"project.yesterday", "", false},
+		{".1700000000", "", false},
+	}
+	for _, tt := range tests {
+		entry, ok := sc.parseTrashEntry(tt.id)
+		if ok != tt.ok {
+			t.Errorf("parseTrashEntry(%q) ok = %v, want %v", tt.id, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if entry.Name != tt.name {
+			t.Errorf("parseTrashEntry(%q).Name = %q, want %q", tt.id, entry.Name, tt.name)
+		}
+		if want := time.Unix(1700000000, 0); !entry.DeletedAt.Equal(want) || !entry.ExpiresAt.Equal(want.Add(time.Hour)) {
+			t.Errorf("parseTrashEntry(%q) = deleted %v, expires %v", tt.id, entry.DeletedAt, entry.ExpiresAt)
+		}
+	}
+}
+
+func TestRestoreRepositoryRejectsPaths(t *testing.T) {
+	sc := NewSmithy(SmithyConfig{Root: t.TempDir()})
+	// An entry whose name climbs out of the root must stay in the trash.
+	if err := os.MkdirAll(filepath.Join(sc.TrashDir(), "..~2fescape.1700000000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{
+		"..~2fescape.1700000000",
+		"../escape.1700000000",
+		"sub/project.1700000000",
+		"missing.1700000000",
+	} {
+		if _, err := sc.RestoreRepository(id); err == nil {
+			t.Errorf("RestoreRepository(%q) succeeded", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sc.Root, "..", "escape")); err == nil {
+		t.Errorf("trash entry was restored outside the root")
+	}
+}
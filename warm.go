@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// WARM_TIMEOUT bounds the background work done after a push.
+var WARM_TIMEOUT = 2 * time.Minute
+
+// WarmCaches fills the caches behind the pages most likely to be visited
+// after a push: the repository page, the default branch tree and log, and
+// the commit counts of badges and stats, the language statistics and the
+// activity graph.
+func (sc *Smithy) WarmCaches(repo RepositoryWithName) {
+	ctx, cancel := context.WithTimeout(context.Background(), WARM_TIMEOUT)
+	defer cancel()
+	release, err := sc.AcquireHeavy(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	main, revision, err := sc.MainBranch(repo)
+	if err != nil {
+		return
+	}
+	commit, err := repo.Repository.CommitObject(*revision)
+	if err != nil {
+		return
+	}
+	settings := sc.RepoSettings(repo)
+	if readme, err := GetReadmeFromCommit(commit, settings.Readme); err == nil {
+		sc.renders.Readme(readme, fmt.Sprintf("/%s/tree/%s", repo.Name, main))
+	}
+	if _, err := sc.lastChanges.Get(ctx, commit, ""); err != nil {
+		log.Printf("warming %s: %v", repo.Name, err)
+		return
+	}
+	if _, err := sc.children.Children(repo, commit.Hash); err != nil {
+		log.Printf("warming %s: %v", repo.Name, err)
+		return
+	}
+	if _, err := CountCommits(ctx, repo, commit.Hash); err != nil {
+		log.Printf("warming %s: %v", repo.Name, err)
+		return
+	}
+	if _, err := sc.stats.Get(ctx, repo); err != nil {
+		log.Printf("warming %s: %v", repo.Name, err)
+		return
+	}
+	if _, err := sc.languages.Get(ctx, repo, commit); err != nil {
+		log.Printf("warming %s: %v", repo.Name, err)
+		return
+	}
+	if _, err := sc.activity.Get(ctx, repo, commit.Hash); err != nil {
+		log.Printf("warming %s: %v", repo.Name, err)
+		return
+	}
+	log.Printf("warmed caches of %s in %s", repo.Name, time.Since(start))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WATCH_SETTLE is how long the root must be quiet after a change before it
+// is scanned again, giving git time to finish creating a repository.
+var WATCH_SETTLE = time.Second
+
+// RescanRepositories picks up repositories added to or removed from the
+// root since the last scan. Handles of repositories that are still there
+// stay open.
+func (sc *Smithy) RescanRepositories() error {
+	repos, err := sc.scanRoot()
+	if err != nil {
+		return err
+	}
+	var gone, added []string
+	sc.reposMu.Lock()
+	for name := range sc.repos {
+		if _, ok := repos[name]; !ok {
+			gone = append(gone, name)
+		}
+	}
+	for name := range repos {
+		if _, ok := sc.repos[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sc.repos = repos
+	sc.reposMu.Unlock()
+
+	for _, name := range gone {
+		sc.handles.Remove(name)
+		log.Printf("repository %s disappeared", name)
+	}
+	for _, name := range added {
+		log.Printf("repository %s appeared", name)
+		if repo, err := sc.openRepo(repos[name]); err == nil {
+			go sc.UpdateSearchIndex(repo)
+		}
+	}
+	changed := len(gone) > 0 || len(added) > 0
+	if changed {
+		sc.pages.Purge()
+	}
+	return nil
+}
+
+// watchNamespaces watches dir and the namespace directories below it.
+func watchNamespaces(watcher *fsnotify.Watcher, dir string) error {
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		sub := filepath.Join(dir, f.Name())
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") || isRepository(sub) {
+			continue
+		}
+		if err := watchNamespaces(watcher, sub); err != nil {
+			log.Printf("watching %s: %v", sub, err)
+		}
+	}
+	return nil
+}
+
+// WatchRoot rescans the root whenever entries are created, removed or
+// renamed in it or in its namespace directories.
+func (sc *Smithy) WatchRoot() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watchNamespaces(watcher, sc.Root); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		settle := time.NewTimer(WATCH_SETTLE)
+		settle.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+				settle.Reset(WATCH_SETTLE)
+			case <-settle.C:
+				if err := watchNamespaces(watcher, sc.Root); err != nil {
+					log.Printf("watching %s: %v", sc.Root, err)
+				}
+				if err := sc.RescanRepositories(); err != nil {
+					log.Printf("rescanning %s: %v", sc.Root, err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("watching %s: %v", sc.Root, err)
+			}
+		}
+	}()
+	return nil
+}
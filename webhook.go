@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// WEBHOOK_MAX_COMMITS limits the commits listed in a push payload.
+// WEBHOOK_WALK_LIMIT is how much of the old history of a ref is excluded
+// from them.
+var (
+	WEBHOOK_MAX_COMMITS = 20
+	WEBHOOK_WALK_LIMIT  = 10000
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type WebhookConfig struct {
+	URL string
+	// Secret signs payloads with HMAC-SHA256 when set.
+	Secret string
+}
+
+// WebhookMap is a flag.Value accepting "repo=url", repeatable.
+type WebhookMap map[string][]WebhookConfig
+
+func (m WebhookMap) String() string {
+	var hooks []string
+	for repo, configs := range m {
+		for _, c := range configs {
+			hooks = append(hooks, repo+"="+c.URL)
+		}
+	}
+	return strings.Join(hooks, ",")
+}
+
+func (m WebhookMap) Set(value string) error {
+	repo, url, found := strings.Cut(value, "=")
+	if !found {
+		return fmt.Errorf("expected repo=url, got %q", value)
+	}
+	m[repo] = append(m[repo], WebhookConfig{URL: url})
+	return nil
+}
+
+// RefUpdate is a ref changed by a push. Old is zero for new refs and New is
+// zero for deleted ones.
+type RefUpdate struct {
+	Name plumbing.ReferenceName
+	Old  plumbing.Hash
+	New  plumbing.Hash
+}
+
+// SnapshotRefs maps every ref of the repository to the hash it points at.
+func SnapshotRefs(r *git.Repository) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	refs := make(map[plumbing.ReferenceName]plumbing.Hash)
+	it, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	err = it.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name()] = ref.Hash()
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// DiffRefs lists the refs that differ between two snapshots.
+func DiffRefs(before, after map[plumbing.ReferenceName]plumbing.Hash) []RefUpdate {
+	var updates []RefUpdate
+	for name, hash := range after {
+		if before[name] != hash {
+			updates = append(updates, RefUpdate{Name: name, Old: before[name], New: hash})
+		}
+	}
+	for name, hash := range before {
+		if _, ok := after[name]; !ok {
+			updates = append(updates, RefUpdate{Name: name, Old: hash, New: plumbing.ZeroHash})
+		}
+	}
+	return updates
+}
+
+// OnPush runs everything that reacts to refs changed by a push.
+func (sc *Smithy) OnPush(repo RepositoryWithName, updates []RefUpdate, baseURL string) {
+	for _, u := range updates {
+		log.Printf("push to %s: %s %s..%s", repo.Name, u.Name, u.Old, u.New)
+	}
+	sc.pages.Purge()
+	// Mail and webhooks are sent in the background so that the push does
+	// not wait for the mail server or hook endpoints.
+	go func() {
+		pushes := make([]RefPush, 0, len(updates))
+		for _, u := range updates {
+			pushes = append(pushes, RefPush{RefUpdate: u, Commits: PushedCommits(repo.Repository, u)})
+		}
+		sc.SendWebhooks(repo, pushes, baseURL)
+		sc.NotifyWatchers(repo, pushes, baseURL)
+	}()
+	go sc.WarmCaches(repo)
+	go sc.UpdateSearchIndex(repo)
+}
+
+type WebhookAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type WebhookCommit struct {
+	ID        string        `json:"id"`
+	Message   string        `json:"message"`
+	Timestamp time.Time     `json:"timestamp"`
+	URL       string        `json:"url"`
+	Author    WebhookAuthor `json:"author"`
+}
+
+type WebhookPayload struct {
+	Repository string          `json:"repository"`
+	Ref        string          `json:"ref"`
+	Before     string          `json:"before"`
+	After      string          `json:"after"`
+	Commits    []WebhookCommit `json:"commits"`
+}
+
+// RefPush is a ref update with the commits it added, see PushedCommits.
+type RefPush struct {
+	RefUpdate
+	Commits []*WebhookCommit
+}
+
+// PushedCommits lists the commits reachable from the new value of the ref
+// but not from the old one, newest first.
+func PushedCommits(r *git.Repository, u RefUpdate) []*WebhookCommit {
+	var commits []*WebhookCommit
+	if u.New.IsZero() {
+		return commits
+	}
+	head, err := r.CommitObject(u.New)
+	if err != nil {
+		return commits
+	}
+	var seen map[plumbing.Hash]bool
+	if !u.Old.IsZero() {
+		if old, err := r.CommitObject(u.Old); err == nil {
+			if seen, err = ancestors(context.Background(), []*object.Commit{old}, WEBHOOK_WALK_LIMIT); err != nil {
+				return commits
+			}
+		}
+	}
+	cIter := object.NewCommitIterCTime(head, seen, nil)
+	defer cIter.Close()
+	for len(commits) < WEBHOOK_MAX_COMMITS {
+		c, err := cIter.Next()
+		if err != nil {
+			break
+		}
+		commits = append(commits, &WebhookCommit{
+			ID:        c.Hash.String(),
+			Message:   c.Message,
+			Timestamp: c.Committer.When,
+			Author:    WebhookAuthor{Name: c.Author.Name, Email: c.Author.Email},
+		})
+	}
+	return commits
+}
+
+func (sc *Smithy) SendWebhooks(repo RepositoryWithName, pushes []RefPush, baseURL string) {
+	hooks := sc.Config().Webhooks[repo.Name]
+	if len(hooks) == 0 {
+		return
+	}
+	for _, u := range pushes {
+		payload := WebhookPayload{
+			Repository: repo.Name,
+			Ref:        u.Name.String(),
+			Before:     u.Old.String(),
+			After:      u.New.String(),
+			Commits:    []WebhookCommit{},
+		}
+		for _, c := range u.Commits {
+			commit := *c
+			commit.URL = fmt.Sprintf("%s/%s/commit/%s", baseURL, repo.Name, c.ID)
+			payload.Commits = append(payload.Commits, commit)
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("webhook %s: %v", repo.Name, err)
+			continue
+		}
+		for _, hook := range hooks {
+			go DeliverWebhook(hook, body)
+		}
+	}
+}
+
+// DeliverWebhook POSTs body to the hook, signing it if the hook has a
+// secret.
+func DeliverWebhook(hook WebhookConfig, body []byte) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("webhook %s: %v", hook.URL, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "smithy-webhook")
+	req.Header.Set("X-Smithy-Event", "push")
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Smithy-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Printf("webhook %s: %v", hook.URL, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("webhook %s: %s", hook.URL, resp.Status)
+	}
+}
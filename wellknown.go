@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RegisterWellKnown serves handler at /.well-known/<name>, so discovery
+// protocols can be added without touching the route table.
+func (sc *Smithy) RegisterWellKnown(name string, handler http.HandlerFunc) {
+	sc.wellKnown[name] = handler
+}
+
+// WellKnownView dispatches /.well-known/ requests to the registered handlers.
+// The bare prefix lists what is available.
+func (sc *Smithy) WellKnownView(w http.ResponseWriter, r *http.Request) {
+	name := sc.GetParam(r, "name")
+	if name == "" {
+		names := make([]string, 0, len(sc.wellKnown))
+		for n := range sc.wellKnown {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		sc.JSON(w, http.StatusOK, names)
+		return
+	}
+	handler, ok := sc.wellKnown[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+type JRDLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// JRD is a JSON Resource Descriptor as defined by RFC 7033.
+type JRD struct {
+	Subject string    `json:"subject"`
+	Aliases []string  `json:"aliases,omitempty"`
+	Links   []JRDLink `json:"links"`
+}
+
+// webfingerRepoName extracts the repository from an acct: URI or a URL
+// pointing at the repository on this host.
+func webfingerRepoName(resource, host string) (string, bool) {
+	if acct, ok := strings.CutPrefix(resource, "acct:"); ok {
+		name, domain, found := strings.Cut(acct, "@")
+		if !found || !strings.EqualFold(domain, host) {
+			return "", false
+		}
+		return name, true
+	}
+	u, err := url.Parse(resource)
+	if err != nil || !strings.EqualFold(u.Host, host) {
+		return "", false
+	}
+	name := strings.Trim(u.Path, "/")
+	return name, name != ""
+}
+
+// WebFinger describes repositories addressed as acct:repo@host.
+func (sc *Smithy) WebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		http.Error(w, "Missing resource", http.StatusBadRequest)
+		return
+	}
+	name, ok := webfingerRepoName(resource, r.Host)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	repo, exists := sc.FindRepo(name)
+	if !exists || !sc.CanRead(r, repo) {
+		http.NotFound(w, r)
+		return
+	}
+
+	repoURL := fmt.Sprintf("%s/%s", sc.BaseURL(r), repo.Name)
+	jrd := JRD{
+		Subject: resource,
+		Aliases: []string{repoURL},
+		Links: []JRDLink{
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: repoURL},
+			{Rel: "alternate", Type: "application/atom+xml", Href: repoURL + "/tags.atom"},
+		},
+	}
+	if branch, _, err := sc.MainBranch(repo); err == nil {
+		jrd.Links = append(jrd.Links, JRDLink{
+			Rel:  "alternate",
+			Type: "application/atom+xml",
+			Href: fmt.Sprintf("%s/log/%s.atom", repoURL, branch),
+		})
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(jrd)
+}